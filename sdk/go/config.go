@@ -0,0 +1,287 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Validate checks conf, the configuration supplied for a single policy, against this schema and
+// returns a human-readable message for every violation found. Messages are sorted by property
+// name so that output is stable across runs.
+func (s *PolicyConfigSchema) Validate(conf map[string]interface{}) []string {
+	if s == nil {
+		return nil
+	}
+
+	required := make(map[string]bool, len(s.Required))
+	for _, r := range s.Required {
+		required[r] = true
+	}
+
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []string
+	for _, name := range names {
+		value, ok := conf[name]
+		if !ok {
+			if required[name] {
+				errs = append(errs, fmt.Sprintf("%s is required", name))
+			}
+			continue
+		}
+		prop := s.Properties[name]
+		errs = append(errs, prop.validate(name, value)...)
+	}
+	return errs
+}
+
+// validate checks a single property value against its JSON Schema fragment, returning a message
+// for every violation, each prefixed with the property's name.
+func (s PolicyConfigJSONSchema) validate(name string, value interface{}) []string {
+	var errs []string
+
+	if len(s.Types) > 0 {
+		matched := false
+		for _, t := range s.Types {
+			if valueMatchesType(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			var want []string
+			for _, t := range s.Types {
+				want = append(want, string(t))
+			}
+			errs = append(errs, fmt.Sprintf("%s: Invalid type. Expected: %s, given: %s",
+				name, strings.Join(want, ", "), typeName(value)))
+			return errs
+		}
+	}
+
+	if str, ok := value.(string); ok {
+		if s.MaxLength != nil && len(str) > *s.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s: String length must be less than or equal to %d", name, *s.MaxLength))
+		}
+		if s.MinLength != nil && len(str) < *s.MinLength {
+			errs = append(errs, fmt.Sprintf("%s: String length must be greater than or equal to %d", name, *s.MinLength))
+		}
+	}
+
+	if f, ok := asFloat(value); ok {
+		if s.Maximum != nil && f > float64(*s.Maximum) {
+			errs = append(errs, fmt.Sprintf("%s: Must be less than or equal to %d", name, *s.Maximum))
+		}
+		if s.Minimum != nil && f < float64(*s.Minimum) {
+			errs = append(errs, fmt.Sprintf("%s: Must be greater than or equal to %d", name, *s.Minimum))
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		matched := false
+		for _, e := range s.Enum {
+			if fmt.Sprint(value) == string(e) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, fmt.Sprintf("%s: %s must be one of the following: %s", name, name, quoteSchemaValues(s.Enum)))
+		}
+	}
+
+	if len(s.Const) > 0 && fmt.Sprint(value) != string(s.Const[0]) {
+		errs = append(errs, fmt.Sprintf("%s: %s does not match: %q", name, name, string(s.Const[0])))
+	}
+
+	return errs
+}
+
+func quoteSchemaValues(values []PolicyConfigJSONSchemaType) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", string(v))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// valueMatchesType reports whether value is an instance of the given JSON Schema primitive type.
+func valueMatchesType(value interface{}, t PolicyConfigJSONSchemaTypeName) bool {
+	switch t {
+	case PolicyConfigJSONSchemaTypeNameNull:
+		return value == nil
+	case PolicyConfigJSONSchemaTypeNameBoolean:
+		_, ok := value.(bool)
+		return ok
+	case PolicyConfigJSONSchemaTypeNameString:
+		_, ok := value.(string)
+		return ok
+	case PolicyConfigJSONSchemaTypeNameInteger:
+		f, ok := asFloat(value)
+		return ok && f == float64(int64(f))
+	case PolicyConfigJSONSchemaTypeNameNumber:
+		_, ok := asFloat(value)
+		return ok
+	case PolicyConfigJSONSchemaTypeNameObject:
+		return value != nil && reflect.ValueOf(value).Kind() == reflect.Map
+	case PolicyConfigJSONSchemaTypeNameArray:
+		return value != nil && reflect.ValueOf(value).Kind() == reflect.Slice
+	default:
+		return false
+	}
+}
+
+// typeName returns the JSON Schema primitive type name describing value's Go representation.
+func typeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	default:
+		if f, ok := asFloat(v); ok {
+			if f == float64(int64(f)) {
+				return "integer"
+			}
+			return "number"
+		}
+		switch reflect.ValueOf(value).Kind() {
+		case reflect.Map:
+			return "object"
+		case reflect.Slice, reflect.Array:
+			return "array"
+		default:
+			return "unknown"
+		}
+	}
+}
+
+// asFloat converts any of the numeric types that a decoded policy config value might take on
+// (plain Go literals as well as the float64/JSON-number representation produced by structpb) into
+// a float64, for uniform comparison against Minimum/Maximum and integer-ness checks.
+func asFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// validateConfigSchema checks that schema is well-formed: every required property must be
+// declared, and length/range bounds must be internally consistent.
+func validateConfigSchema(schema *PolicyConfigSchema) error {
+	if schema == nil {
+		return nil
+	}
+	for _, req := range schema.Required {
+		if _, ok := schema.Properties[req]; !ok {
+			return fmt.Errorf("required property %q is not declared in properties", req)
+		}
+	}
+	for name, prop := range schema.Properties {
+		if prop.MinLength != nil && prop.MaxLength != nil && *prop.MinLength > *prop.MaxLength {
+			return fmt.Errorf("property %q: minLength must be less than or equal to maxLength", name)
+		}
+		if prop.Minimum != nil && prop.Maximum != nil && *prop.Minimum > *prop.Maximum {
+			return fmt.Errorf("property %q: minimum must be less than or equal to maximum", name)
+		}
+	}
+	return nil
+}
+
+// defaults returns the default values declared in schema, keyed by property name. Properties with
+// no "default" are omitted.
+func (s *PolicyConfigSchema) defaults() map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+	defaults := map[string]interface{}{}
+	for name, prop := range s.Properties {
+		if prop.Default != nil {
+			defaults[name] = prop.convertDefault()
+		}
+	}
+	return defaults
+}
+
+// convertDefault parses this property's Default -- stored as a bare string (PolicyConfigJSONSchemaType
+// is a string type) regardless of the property's declared type -- into a Go value matching its
+// declared Types, so e.g. a "number" property defaults to a float64 and not the literal string "5".
+// Without this, the defaulted config fails the schema's own Validate, and GetConfigAs fails to
+// decode the default into a typed struct field. Falls back to the literal string if Types doesn't
+// name a more specific conversion, or if the literal doesn't parse as the declared type.
+func (s PolicyConfigJSONSchema) convertDefault() interface{} {
+	literal := string(*s.Default)
+	for _, t := range s.Types {
+		switch t {
+		case PolicyConfigJSONSchemaTypeNameBoolean:
+			if b, err := strconv.ParseBool(literal); err == nil {
+				return b
+			}
+		case PolicyConfigJSONSchemaTypeNameNumber, PolicyConfigJSONSchemaTypeNameInteger:
+			if f, err := strconv.ParseFloat(literal, 64); err == nil {
+				return f
+			}
+		case PolicyConfigJSONSchemaTypeNameObject, PolicyConfigJSONSchemaTypeNameArray:
+			var v interface{}
+			if err := json.Unmarshal([]byte(literal), &v); err == nil {
+				return v
+			}
+		case PolicyConfigJSONSchemaTypeNameNull:
+			return nil
+		}
+	}
+	return literal
+}
+
+// schemaPropertiesToMap converts a ConfigSchema's declared properties -- a map of typed Go structs
+// describing each property's JSON Schema fragment -- into a plain map[string]interface{} suitable
+// for resource.NewPropertyMapFromMap. Unlike resource.NewPropertyMap, NewPropertyMapFromMap doesn't
+// require a tagged Go struct, so this round-trips through JSON (every field here already carries a
+// "json" tag) rather than handing the struct map to NewPropertyMap directly.
+func schemaPropertiesToMap(props map[string]PolicyConfigJSONSchema) (map[string]interface{}, error) {
+	if len(props) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal(props)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling configSchema properties: %w", err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decoding configSchema properties: %w", err)
+	}
+	return out, nil
+}
+
+// withDefaults merges raw (the configuration supplied by the engine for a single policy) over this
+// schema's declared defaults, so that policy authors always see a fully-populated configuration.
+func (s *PolicyConfigSchema) withDefaults(raw map[string]interface{}) map[string]interface{} {
+	merged := s.defaults()
+	if merged == nil {
+		merged = map[string]interface{}{}
+	}
+	for k, v := range raw {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,35 @@
+package policy
+
+// PolicyContext carries the ambient information about the stack being analyzed that a policy
+// needs for per-stack conditional logic, e.g. "only enforce encryption in prod stacks".
+//
+// The analyzer protocol this SDK targets only threads Project through today (via the
+// PULUMI_PROJECT environment variable the engine sets when it launches the analyzer process, the
+// same one Run already reads). Stack, Organization, DryRun, and Tags are not present on
+// ConfigureAnalyzerRequest in this version of the protocol, so they're left at their zero value
+// until a newer wire protocol carries them.
+type PolicyContext struct {
+	DryRun       bool
+	Stack        string
+	Project      string
+	Organization string
+	Tags         map[string]string
+}
+
+// GetTags returns this context's stack tags, or nil if ctx itself is nil.
+func (ctx *PolicyContext) GetTags() map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Tags
+}
+
+// GetContext returns the PolicyContext in effect for this invocation.
+func (args ResourceValidationArgs) GetContext() *PolicyContext {
+	return args.policyContext
+}
+
+// GetContext returns the PolicyContext in effect for this invocation.
+func (args StackValidationArgs) GetContext() *PolicyContext {
+	return args.policyContext
+}
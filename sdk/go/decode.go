@@ -0,0 +1,169 @@
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+)
+
+// decodeResource reflect-decodes pm into the struct out points to, field by field, using each
+// field's "pulumi" struct tag to find its matching property (falling back to "json", then the
+// field's own name, the same fallback order a Pulumi resource's own inputs/outputs struct uses).
+//
+// A secret's underlying value is unwrapped transparently -- a policy almost never needs to
+// distinguish "secret string" from "string" when deciding whether the value is, say, too short.
+// A computed or not-yet-known output (as seen during a preview of a resource that depends on one
+// not yet created) decodes as that field's zero value, since there is no value yet to decode; see
+// OnUnknown for the policy-level way to detect this instead of relying on a zero value being
+// suspicious. An asset or archive decodes directly into a resource.Asset/resource.Archive field,
+// or into a string (its URI or hash) for any other field type.
+func decodeResource(pm resource.PropertyMap, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode target must be a non-nil pointer, got %T", out)
+	}
+	return decodeObject(pm, rv.Elem())
+}
+
+func decodeObject(pm resource.PropertyMap, target reflect.Value) error {
+	switch target.Kind() {
+	case reflect.Struct:
+		t := target.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" { // unexported
+				continue
+			}
+			key := propertyKey(field)
+			if key == "-" {
+				continue
+			}
+			pv, ok := pm[resource.PropertyKey(key)]
+			if !ok {
+				continue
+			}
+			if err := decodeValue(pv, target.Field(i)); err != nil {
+				return fmt.Errorf("property %q into field %q: %w", key, field.Name, err)
+			}
+		}
+		return nil
+	case reflect.Map:
+		out := reflect.MakeMapWithSize(target.Type(), len(pm))
+		for k, v := range pm {
+			elem := reflect.New(target.Type().Elem()).Elem()
+			if err := decodeValue(v, elem); err != nil {
+				return fmt.Errorf("property %q: %w", k, err)
+			}
+			out.SetMapIndex(reflect.ValueOf(string(k)).Convert(target.Type().Key()), elem)
+		}
+		target.Set(out)
+		return nil
+	case reflect.Interface:
+		target.Set(reflect.ValueOf(pm.Mappable()))
+		return nil
+	default:
+		return fmt.Errorf("cannot decode an object into a field of kind %s", target.Kind())
+	}
+}
+
+// propertyKey returns the property name field should be populated from: its "pulumi" tag, falling
+// back to its "json" tag, falling back to its own Go name.
+func propertyKey(field reflect.StructField) string {
+	if tag, ok := field.Tag.Lookup("pulumi"); ok {
+		return firstTagElement(tag)
+	}
+	if tag, ok := field.Tag.Lookup("json"); ok {
+		return firstTagElement(tag)
+	}
+	return field.Name
+}
+
+func firstTagElement(tag string) string {
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+func decodeValue(pv resource.PropertyValue, target reflect.Value) error {
+	switch {
+	case pv.IsSecret():
+		return decodeValue(pv.SecretValue().Element, target)
+	case pv.IsOutput():
+		ov := pv.OutputValue()
+		if !ov.Known {
+			return nil
+		}
+		return decodeValue(ov.Element, target)
+	case pv.IsComputed(), pv.IsNull():
+		return nil
+	case pv.IsBool():
+		if target.Kind() != reflect.Bool {
+			return fmt.Errorf("expected a bool field, got %s", target.Kind())
+		}
+		target.SetBool(pv.BoolValue())
+		return nil
+	case pv.IsNumber():
+		switch target.Kind() {
+		case reflect.Float32, reflect.Float64:
+			target.SetFloat(pv.NumberValue())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			target.SetInt(int64(pv.NumberValue()))
+		default:
+			return fmt.Errorf("expected a numeric field, got %s", target.Kind())
+		}
+		return nil
+	case pv.IsString():
+		if target.Kind() != reflect.String {
+			return fmt.Errorf("expected a string field, got %s", target.Kind())
+		}
+		target.SetString(pv.StringValue())
+		return nil
+	case pv.IsArray():
+		if target.Kind() != reflect.Slice {
+			return fmt.Errorf("expected a slice field, got %s", target.Kind())
+		}
+		arr := pv.ArrayValue()
+		out := reflect.MakeSlice(target.Type(), len(arr), len(arr))
+		for i, e := range arr {
+			if err := decodeValue(e, out.Index(i)); err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+		}
+		target.Set(out)
+		return nil
+	case pv.IsObject():
+		return decodeObject(pv.ObjectValue(), target)
+	case pv.IsAsset():
+		return decodeAssetOrArchive(pv.AssetValue(), target)
+	case pv.IsArchive():
+		return decodeAssetOrArchive(pv.ArchiveValue(), target)
+	case pv.IsResourceReference():
+		if target.Kind() != reflect.String {
+			return fmt.Errorf("expected a string field for a resource reference, got %s", target.Kind())
+		}
+		target.SetString(string(pv.ResourceReferenceValue().URN))
+		return nil
+	default:
+		return fmt.Errorf("unsupported property type %q", pv.TypeString())
+	}
+}
+
+// decodeAssetOrArchive assigns v (a *resource.Asset or *resource.Archive) directly into target if
+// it's assignable there, otherwise falls back to target's string representation -- its URI for an
+// asset, or its own String() form for an archive -- for a field that just wants to know which
+// file/directory it is without needing the asset/archive type itself.
+func decodeAssetOrArchive(v interface{}, target reflect.Value) error {
+	rv := reflect.ValueOf(v)
+	if rv.IsValid() && rv.Type().AssignableTo(target.Type()) {
+		target.Set(rv)
+		return nil
+	}
+	if target.Kind() == reflect.String {
+		target.SetString(fmt.Sprintf("%v", v))
+		return nil
+	}
+	return fmt.Errorf("cannot decode %T into a field of type %s", v, target.Type())
+}
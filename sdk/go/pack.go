@@ -0,0 +1,579 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-policy/sdk/go/version"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+
+	pbempty "github.com/golang/protobuf/ptypes/empty"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
+	logger "github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/rpcutil"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var policyPackNameRE = regexp.MustCompile(`^[a-zA-Z0-9-_.]{1,100}$`)
+
+// PackBuilder assembles a policy pack out of resource policies, stack policies, remediations, and
+// provider policies, then serves it over the analyzer gRPC protocol. Use NewPack to create one; a
+// pack built this way can freely mix all four kinds of policy, unlike the single-kind Pack[T] helper.
+type PackBuilder struct {
+	name             string
+	resourcePolicies Policies[ResourceValidationPolicy]
+	stackPolicies    Policies[StackValidationPolicy]
+	remediations     Policies[ResourceRemediationPolicy]
+	providerPolicies Policies[ProviderValidationPolicy]
+}
+
+// NewPack starts building a policy pack named name.
+func NewPack(name string) *PackBuilder {
+	return &PackBuilder{name: name}
+}
+
+// AddResourcePolicy adds a policy that validates individual resources.
+func (b *PackBuilder) AddResourcePolicy(p Policy[ResourceValidationPolicy]) *PackBuilder {
+	b.resourcePolicies = append(b.resourcePolicies, p)
+	return b
+}
+
+// AddStackPolicy adds a policy that validates the whole stack at once, e.g. to check invariants
+// across multiple resources.
+func (b *PackBuilder) AddStackPolicy(p Policy[StackValidationPolicy]) *PackBuilder {
+	b.stackPolicies = append(b.stackPolicies, p)
+	return b
+}
+
+// AddRemediation adds a policy that mutates a resource's properties before it, and the rest of the
+// pack's resource policies, see it. Its EnforcementLevel is always reported to the engine as
+// EnforcementLevel_Remediate, regardless of what p.EnforcementLevel is set to, since that's the
+// only enforcement level that makes sense for a policy that fixes a resource rather than just
+// reporting on it.
+func (b *PackBuilder) AddRemediation(p Policy[ResourceRemediationPolicy]) *PackBuilder {
+	p.EnforcementLevel = EnforcementLevel_Remediate
+	b.remediations = append(b.remediations, p)
+	return b
+}
+
+// AddProviderPolicy adds a policy that validates a provider resource's (e.g.
+// "pulumi:providers:aws") pending configuration before any resource it manages is checked.
+func (b *PackBuilder) AddProviderPolicy(p Policy[ProviderValidationPolicy]) *PackBuilder {
+	b.providerPolicies = append(b.providerPolicies, p)
+	return b
+}
+
+// validate checks the pack's name and each policy's ConfigSchema, independent of any config
+// values supplied at runtime.
+func (b *PackBuilder) validate() error {
+	if b.name == "" || !policyPackNameRE.MatchString(b.name) {
+		logger.V(1).Infof("Invalid policy pack name %q. Policy pack names may only contain alphanumerics, hyphens, "+
+			"underscores, or periods.", b.name)
+		return fmt.Errorf("invalid policy pack name: %q", b.name)
+	}
+
+	for _, meta := range b.allPolicyMetas() {
+		if meta.Name == "all" {
+			return fmt.Errorf("invalid policy name %[1]q. %[1]q is a reserved name", meta.Name)
+		}
+
+		if meta.ConfigSchema != nil {
+			if _, ok := meta.ConfigSchema.Properties["enforcementLevel"]; ok {
+				return errors.New("enforcementLevel cannot be explicitly specified in configSchema properties")
+			}
+			for _, req := range meta.ConfigSchema.Required {
+				if req == "enforcementLevel" {
+					return errors.New("enforcementLevel cannot be required in configSchema")
+				}
+			}
+			if err := validateConfigSchema(meta.ConfigSchema); err != nil {
+				return fmt.Errorf("invalid configSchema for policy %q: %w", meta.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Run validates the assembled pack and serves it over the analyzer protocol, blocking until the
+// engine tells it to stop. If invoked as "<binary> validate-config <config.json>" -- the way the
+// `pulumi policy validate-config` CLI path execs a compiled Go policy pack -- it instead validates
+// the config in that file against the pack's ConfigSchemas and returns, without serving the
+// analyzer protocol or provisioning any resources.
+func (b *PackBuilder) Run() error {
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		return b.runValidateConfig(os.Args[2:])
+	}
+
+	if err := b.validate(); err != nil {
+		return err
+	}
+
+	// Fire up a gRPC server, letting the kernel choose a free port for us.
+	port, done, err := rpcutil.Serve(0, nil, []func(*grpc.Server) error{
+		func(srv *grpc.Server) error {
+			analyzer := &analyzerServer{
+				policyPackName:   b.name,
+				resourcePolicies: b.resourcePolicies,
+				stackPolicies:    b.stackPolicies,
+				remediations:     b.remediations,
+				providerPolicies: b.providerPolicies,
+				policyContext: &PolicyContext{
+					Project: os.Getenv("PULUMI_PROJECT"),
+				},
+			}
+			pulumirpc.RegisterAnalyzerServer(srv, analyzer)
+			return nil
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("fatal: %v", err)
+	}
+
+	// The analyzer protocol requires that we now write out the port we have chosen to listen on.
+	fmt.Printf("%d\n", port)
+
+	// Finally, wait for the server to stop serving.
+	if err := <-done; err != nil {
+		return fmt.Errorf("fatal: %v", err)
+	}
+
+	return nil
+}
+
+// runValidateConfig validates the policy config in the file named by args[0] against the pack's
+// ConfigSchemas and returns an error describing any violations found.
+func (b *PackBuilder) runValidateConfig(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s validate-config <config.json>", filepath.Base(os.Args[0]))
+	}
+	if err := b.validate(); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("reading policy config %q: %w", args[0], err)
+	}
+	var raw map[string]map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("parsing policy config %q: %w", args[0], err)
+	}
+	conf := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		conf[k] = v
+	}
+
+	analyzer := &analyzerServer{
+		policyPackName:   b.name,
+		resourcePolicies: b.resourcePolicies,
+		stackPolicies:    b.stackPolicies,
+		remediations:     b.remediations,
+		providerPolicies: b.providerPolicies,
+	}
+	if violations := analyzer.validateConfig(conf); len(violations) > 0 {
+		return fmt.Errorf("validating policy config: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// policyMeta is the subset of a Policy[T]'s fields that don't depend on T, so that pack-wide
+// operations (validation, config, info) can treat resource policies, stack policies, and
+// remediations uniformly.
+type policyMeta struct {
+	Name             string
+	Description      string
+	EnforcementLevel EnforcementLevel
+	ConfigSchema     *PolicyConfigSchema
+	TargetURNs       []string
+	ResourceTypes    []string
+	Providers        []string
+}
+
+func policyMetas[T ValidationPolicy](policies Policies[T]) []policyMeta {
+	metas := make([]policyMeta, len(policies))
+	for i, p := range policies {
+		metas[i] = policyMeta{
+			Name:             p.Name,
+			Description:      p.Description,
+			EnforcementLevel: p.EnforcementLevel,
+			ConfigSchema:     p.ConfigSchema,
+			TargetURNs:       p.TargetURNs,
+			ResourceTypes:    p.ResourceTypes,
+			Providers:        p.Providers,
+		}
+	}
+	return metas
+}
+
+// describeScope renders a policyMeta's TargetURNs/ResourceTypes/Providers selectors as a short
+// human-readable suffix for its Description, e.g. " [scope: types=aws:s3/*]", so an operator
+// running `pulumi policy ls` can see at a glance which resources a policy actually applies to.
+// Returns "" if the policy has no selectors at all. The analyzer protocol this SDK targets has no
+// dedicated PolicyInfo field for this, so Description is the only place to surface it.
+func (m policyMeta) describeScope() string {
+	var parts []string
+	if len(m.TargetURNs) > 0 {
+		parts = append(parts, "urns="+strings.Join(m.TargetURNs, ","))
+	}
+	if len(m.ResourceTypes) > 0 {
+		parts = append(parts, "types="+strings.Join(m.ResourceTypes, ","))
+	}
+	if len(m.Providers) > 0 {
+		parts = append(parts, "providers="+strings.Join(m.Providers, ","))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " [scope: " + strings.Join(parts, "; ") + "]"
+}
+
+func (b *PackBuilder) allPolicyMetas() []policyMeta {
+	var all []policyMeta
+	all = append(all, policyMetas(b.resourcePolicies)...)
+	all = append(all, policyMetas(b.stackPolicies)...)
+	all = append(all, policyMetas(b.remediations)...)
+	all = append(all, policyMetas(b.providerPolicies)...)
+	return all
+}
+
+// Pack is sugar for a single-kind policy pack: it builds a PackBuilder from a homogeneous
+// collection of policies and runs it. Use NewPack directly for a pack that mixes resource
+// policies, stack policies, and remediations.
+func Pack[T ValidationPolicy](name string, policies Policies[T]) error {
+	b := NewPack(name)
+	for _, p := range policies {
+		switch v := any(p).(type) {
+		case Policy[ResourceValidationPolicy]:
+			b.AddResourcePolicy(v)
+		case Policy[StackValidationPolicy]:
+			b.AddStackPolicy(v)
+		case Policy[ResourceRemediationPolicy]:
+			b.AddRemediation(v)
+		case Policy[ProviderValidationPolicy]:
+			b.AddProviderPolicy(v)
+		default:
+			contract.Failf("unreachable: unknown ValidationPolicy implementation %T", p)
+		}
+	}
+	return b.Run()
+}
+
+type analyzerServer struct {
+	pulumirpc.UnimplementedAnalyzerServer
+
+	policyPackName   string
+	resourcePolicies Policies[ResourceValidationPolicy]
+	stackPolicies    Policies[StackValidationPolicy]
+	remediations     Policies[ResourceRemediationPolicy]
+	providerPolicies Policies[ProviderValidationPolicy]
+	policyPackConfig map[string]interface{}
+	policyContext    *PolicyContext
+}
+
+func (a *analyzerServer) allPolicyMetas() []policyMeta {
+	var all []policyMeta
+	all = append(all, policyMetas(a.resourcePolicies)...)
+	all = append(all, policyMetas(a.stackPolicies)...)
+	all = append(all, policyMetas(a.remediations)...)
+	all = append(all, policyMetas(a.providerPolicies)...)
+	return all
+}
+
+func (a *analyzerServer) Analyze(ctx context.Context, req *pulumirpc.AnalyzeRequest) (*pulumirpc.AnalyzeResponse, error) {
+	ds := analyzeResourceLike(a, a.resourcePolicies, req, "resource", "properties")
+
+	if isProviderType(req.GetType()) {
+		ds = append(ds, analyzeResourceLike(a, a.providerPolicies, req, "provider", "configuration")...)
+	}
+
+	return &pulumirpc.AnalyzeResponse{
+		Diagnostics: ds,
+	}, nil
+}
+
+// analyzeResourceLike runs policies -- either resource or provider validation policies, which share
+// the same ResourceValidationArgs-based signature and only differ in what kind of thing they're
+// pointed at -- against req, the way Analyze would for a single resource or provider. kind names
+// that flavor ("resource"/"provider") for logging and timing, and unknownNoun names what's unknown
+// about req when OnUnknown fires ("properties"/"configuration"); together they're the only two
+// words that differed between the resource and provider loops this helper replaces.
+func analyzeResourceLike[T ResourceValidationPolicy | ProviderValidationPolicy](
+	a *analyzerServer, policies Policies[T], req *pulumirpc.AnalyzeRequest, kind, unknownNoun string,
+) []*pulumirpc.AnalyzeDiagnostic {
+	var ds []*pulumirpc.AnalyzeDiagnostic
+	for _, p := range policies {
+		if !matchesResourceSelectors(p.TargetURNs, p.ResourceTypes, p.Providers,
+			req.GetUrn(), req.GetType(), req.GetProvider().GetType()) {
+			continue
+		}
+
+		reportViolationV2 := func(violation Violation) {
+			urn := violation.URN
+			if urn == "" {
+				urn = req.GetUrn()
+			}
+			ds = append(ds, &pulumirpc.AnalyzeDiagnostic{
+				PolicyName:       p.Name,
+				PolicyPackName:   a.policyPackName,
+				Description:      p.Description,
+				Message:          formatViolationMessage(p.Description, violation),
+				Tags:             violationTags(violation.Metadata),
+				EnforcementLevel: pulumirpc.EnforcementLevel(resolveEnforcementLevel(p.EnforcementLevel, violation)),
+				Urn:              urn,
+			})
+		}
+		defaultReportViolation := func(message string, urn string) {
+			reportViolationV2(Violation{Message: message, URN: urn})
+		}
+
+		if len(p.AppliesToStackTags) > 0 && !matchesStackTags(p.AppliesToStackTags, a.policyContext.GetTags()) {
+			ds = append(ds, &pulumirpc.AnalyzeDiagnostic{
+				PolicyName:       p.Name,
+				PolicyPackName:   a.policyPackName,
+				Description:      p.Description,
+				Message:          fmt.Sprintf("%s: skipped -- stack tags do not match appliesToStackTags", p.Name),
+				Tags:             []string{"skipped=true"},
+				EnforcementLevel: pulumirpc.EnforcementLevel(p.EnforcementLevel),
+				Urn:              req.GetUrn(),
+			})
+			continue
+		}
+
+		if p.OnUnknown != OnUnknownPassthrough {
+			props, err := plugin.UnmarshalProperties(req.GetProperties(), plugin.MarshalOptions{
+				KeepUnknowns: true,
+				KeepSecrets:  true,
+			})
+			if err == nil && props.ContainsUnknowns() {
+				switch p.OnUnknown {
+				case OnUnknownSkip:
+					ds = append(ds, &pulumirpc.AnalyzeDiagnostic{
+						PolicyName:     p.Name,
+						PolicyPackName: a.policyPackName,
+						Description:    p.Description,
+						Message: fmt.Sprintf("%s: skipped -- %s has unknown %s during preview",
+							p.Name, kind, unknownNoun),
+						Tags:             []string{"skipped=true"},
+						EnforcementLevel: pulumirpc.EnforcementLevel(p.EnforcementLevel),
+						Urn:              req.GetUrn(),
+					})
+				case OnUnknownFail:
+					reportViolationV2(Violation{
+						Message: fmt.Sprintf("cannot validate during preview: %s has unknown %s", kind, unknownNoun),
+					})
+				}
+				continue
+			}
+		}
+
+		args := ResourceValidationArgs{
+			// Parent, Dependencies, and PropertyDependencies aren't available on AnalyzeRequest,
+			// only on AnalyzerResource (AnalyzeStack/Remediate), so they're left zero-valued here.
+			Resource: &pulumirpc.AnalyzerResource{
+				Type:       req.GetType(),
+				Properties: req.GetProperties(),
+				Urn:        req.GetUrn(),
+				Name:       req.GetName(),
+				Options:    req.GetOptions(),
+				Provider:   req.GetProvider(),
+			},
+			config:          p.ConfigSchema.withDefaults(a.configFor(p.Name)),
+			policyContext:   a.policyContext,
+			reportViolation: reportViolationV2,
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "Calling %s validation policy: %q on URN: %q\n", kind, p.Name, req.GetUrn())
+		start := time.Now()
+		p.ValidationPolicy()(args, defaultReportViolation)
+		logPolicyTiming(kind+"-validation", p.Name, req.GetUrn(), start)
+	}
+	return ds
+}
+
+// logPolicyTiming writes how long a single policy invocation took to stderr, in a form a test
+// harness (or a human reading plugin logs) can scrape to catch a policy that's become
+// accidentally slow, e.g. O(n^2) over stack size.
+func logPolicyTiming(kind, policyName, urn string, start time.Time) {
+	_, _ = fmt.Fprintf(os.Stderr, "policy timing: %s %q on %q took %s\n", kind, policyName, urn, time.Since(start))
+}
+
+func (a *analyzerServer) AnalyzeStack(ctx context.Context, req *pulumirpc.AnalyzeStackRequest) (*pulumirpc.AnalyzeResponse,
+	error) {
+	var ds []*pulumirpc.AnalyzeDiagnostic
+	for _, p := range a.stackPolicies {
+		reportViolationV2 := func(violation Violation) {
+			ds = append(ds, &pulumirpc.AnalyzeDiagnostic{
+				PolicyName:       p.Name,
+				PolicyPackName:   a.policyPackName,
+				Description:      p.Description,
+				Message:          formatViolationMessage(p.Description, violation),
+				Tags:             violationTags(violation.Metadata),
+				EnforcementLevel: pulumirpc.EnforcementLevel(resolveEnforcementLevel(p.EnforcementLevel, violation)),
+				Urn:              violation.URN,
+			})
+		}
+		defaultReportViolation := func(message string, urn string) {
+			reportViolationV2(Violation{Message: message, URN: urn})
+		}
+
+		if len(p.AppliesToStackTags) > 0 && !matchesStackTags(p.AppliesToStackTags, a.policyContext.GetTags()) {
+			ds = append(ds, &pulumirpc.AnalyzeDiagnostic{
+				PolicyName:       p.Name,
+				PolicyPackName:   a.policyPackName,
+				Description:      p.Description,
+				Message:          fmt.Sprintf("%s: skipped -- stack tags do not match appliesToStackTags", p.Name),
+				Tags:             []string{"skipped=true"},
+				EnforcementLevel: pulumirpc.EnforcementLevel(p.EnforcementLevel),
+			})
+			continue
+		}
+
+		var resources []*pulumirpc.AnalyzerResource
+		for _, r := range req.GetResources() {
+			resources = append(resources, &pulumirpc.AnalyzerResource{
+				Type:                 r.GetType(),
+				Properties:           r.GetProperties(),
+				Urn:                  r.GetUrn(),
+				Name:                 r.GetName(),
+				Options:              r.GetOptions(),
+				Provider:             r.GetProvider(),
+				Parent:               r.GetParent(),
+				Dependencies:         r.GetDependencies(),
+				PropertyDependencies: r.GetPropertyDependencies(),
+			})
+		}
+		args := StackValidationArgs{
+			Resources:       resources,
+			config:          p.ConfigSchema.withDefaults(a.configFor(p.Name)),
+			policyContext:   a.policyContext,
+			reportViolation: reportViolationV2,
+		}
+		start := time.Now()
+		p.ValidationPolicy()(args, defaultReportViolation)
+		logPolicyTiming("stack-validation", p.Name, "", start)
+	}
+	return &pulumirpc.AnalyzeResponse{
+		Diagnostics: ds,
+	}, nil
+}
+
+func (a *analyzerServer) GetAnalyzerInfo(context.Context, *pbempty.Empty) (*pulumirpc.AnalyzerInfo, error) {
+	var policies []*pulumirpc.PolicyInfo
+	initialConfig := map[string]*pulumirpc.PolicyConfig{}
+
+	for _, p := range a.allPolicyMetas() {
+		var required []string
+		configSchemaProps := resource.NewPropertyMapFromMap(nil)
+		if p.ConfigSchema != nil {
+			propsMap, err := schemaPropertiesToMap(p.ConfigSchema.Properties)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert configSchema properties for policy %q: %w", p.Name, err)
+			}
+			configSchemaProps = resource.NewPropertyMapFromMap(propsMap)
+			required = p.ConfigSchema.Required
+		}
+		props, err := plugin.MarshalProperties(configSchemaProps,
+			plugin.MarshalOptions{KeepSecrets: true})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal properties for policy pack: %q: %w", a.policyPackName, err)
+		}
+		configSchema := pulumirpc.PolicyConfigSchema{
+			Properties: props,
+			Required:   required,
+		}
+
+		policies = append(policies, &pulumirpc.PolicyInfo{
+			Name:             p.Name,
+			Description:      p.Description + p.describeScope(),
+			EnforcementLevel: pulumirpc.EnforcementLevel(p.EnforcementLevel),
+			ConfigSchema:     &configSchema,
+		})
+
+		if defaults := p.ConfigSchema.defaults(); len(defaults) > 0 {
+			defaultProps, err := plugin.MarshalProperties(resource.NewPropertyMapFromMap(defaults),
+				plugin.MarshalOptions{KeepSecrets: true})
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal default config for policy %q: %w", p.Name, err)
+			}
+			initialConfig[p.Name] = &pulumirpc.PolicyConfig{
+				EnforcementLevel: pulumirpc.EnforcementLevel(p.EnforcementLevel),
+				Properties:       defaultProps,
+			}
+		}
+	}
+	if len(initialConfig) == 0 {
+		initialConfig = nil
+	}
+	return &pulumirpc.AnalyzerInfo{
+		Name:           a.policyPackName,
+		Policies:       policies,
+		SupportsConfig: true,
+		InitialConfig:  initialConfig,
+	}, nil
+}
+
+// configFor returns the raw (not yet defaulted) configuration the engine supplied for the named
+// policy, or nil if none was supplied.
+func (a *analyzerServer) configFor(policyName string) map[string]interface{} {
+	if a.policyPackConfig == nil {
+		return nil
+	}
+	conf, _ := a.policyPackConfig[policyName].(map[string]interface{})
+	return conf
+}
+
+func (a *analyzerServer) GetPluginInfo(context.Context, *pbempty.Empty) (*pulumirpc.PluginInfo, error) {
+	return &pulumirpc.PluginInfo{
+		Version: version.Version,
+	}, nil
+}
+
+func (a *analyzerServer) Configure(ctx context.Context, req *pulumirpc.ConfigureAnalyzerRequest) (*pbempty.Empty,
+	error) {
+	conf := map[string]interface{}{}
+	for k, v := range req.PolicyConfig {
+		pm, err := plugin.UnmarshalProperties(v.GetProperties(), plugin.MarshalOptions{
+			Label:        fmt.Sprintf("%s.configure", a.policyPackName),
+			KeepUnknowns: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		conf[k] = pm.Mappable()
+	}
+
+	if violations := a.validateConfig(conf); len(violations) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "validating policy config: %s", strings.Join(violations, "; "))
+	}
+
+	a.policyPackConfig = conf
+	return &pbempty.Empty{}, nil
+}
+
+// validateConfig checks conf, keyed by policy name, against each policy's ConfigSchema, returning
+// one message per violation found. It performs the same check Configure does, but without mutating
+// a.policyPackConfig, so it can also back a validate-config entrypoint that runs ahead of, and
+// independently from, Configure and the rest of the deployment.
+func (a *analyzerServer) validateConfig(conf map[string]interface{}) []string {
+	var violations []string
+	for _, p := range a.allPolicyMetas() {
+		if p.ConfigSchema == nil {
+			continue
+		}
+		raw, _ := conf[p.Name].(map[string]interface{})
+		for _, msg := range p.ConfigSchema.Validate(p.ConfigSchema.withDefaults(raw)) {
+			violations = append(violations, fmt.Sprintf("%s %s: %s", a.policyPackName, p.Name, msg))
+		}
+	}
+	return violations
+}
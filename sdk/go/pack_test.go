@@ -0,0 +1,55 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
+)
+
+// TestGetAnalyzerInfoConfigSchemaDefaults exercises GetAnalyzerInfo end to end against a policy
+// with a non-trivial ConfigSchema: a "number"-typed property carrying a Default. It would have
+// caught two bugs at once -- GetAnalyzerInfo panicking while building the schema's Properties, and
+// its InitialConfig coercing that default to the string "5" instead of the number 5 -- neither of
+// which any prior test exercised.
+func TestGetAnalyzerInfoConfigSchemaDefaults(t *testing.T) {
+	five := PolicyConfigJSONSchemaType("5")
+	pack := NewPack("test-pack").AddResourcePolicy(Policy[ResourceValidationPolicy]{
+		Name:        "replica-count",
+		Description: "Requires a minimum replica count.",
+		ConfigSchema: &PolicyConfigSchema{
+			Properties: map[string]PolicyConfigJSONSchema{
+				"replicas": {
+					Types:   []PolicyConfigJSONSchemaTypeName{PolicyConfigJSONSchemaTypeNameNumber},
+					Default: &five,
+				},
+			},
+		},
+		ValidationPolicy: func() ResourceValidationPolicy {
+			return func(args ResourceValidationArgs, reportViolation ReportViolation) {}
+		},
+	})
+
+	info, err := pack.Harness().GetAnalyzerInfo()
+	if err != nil {
+		t.Fatalf("GetAnalyzerInfo: %v", err)
+	}
+
+	conf, ok := info.InitialConfig["replica-count"]
+	if !ok {
+		t.Fatalf("expected InitialConfig for %q, got %v", "replica-count", info.InitialConfig)
+	}
+
+	props, err := plugin.UnmarshalProperties(conf.Properties, plugin.MarshalOptions{KeepSecrets: true})
+	if err != nil {
+		t.Fatalf("unmarshaling initial config properties: %v", err)
+	}
+
+	replicas, ok := props["replicas"]
+	if !ok || !replicas.IsNumber() || replicas.NumberValue() != 5 {
+		t.Fatalf("expected replicas default of the number 5, got %v", replicas)
+	}
+
+	if err := pack.Harness().ValidateConfig(nil); err != nil {
+		t.Fatalf("ValidateConfig with only defaults applied: %v", err)
+	}
+}
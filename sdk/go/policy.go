@@ -1,30 +1,36 @@
 package policy
 
 import (
-	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
-	"github.com/pulumi/pulumi-policy/sdk/go/version"
-	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
-	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 	"os"
-	"regexp"
 
-	pbempty "github.com/golang/protobuf/ptypes/empty"
-	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
-	logger "github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
-	"github.com/pulumi/pulumi/sdk/v3/go/common/util/rpcutil"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
-	"google.golang.org/grpc"
 )
 
+// decodeConfig round-trips conf through JSON into out, a pointer to a user-provided Go struct, so
+// its own "json" struct tags drive the decode. Unlike a resource's properties (see decodeResource
+// in decode.go), policy config is already plain Go values -- no secrets, computed markers, or
+// asset/archive types to unwrap -- so a JSON round-trip is sufficient here.
+func decodeConfig(conf map[string]interface{}, out interface{}) error {
+	raw, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("marshaling policy config: %w", err)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("decoding policy config into %T: %w", out, err)
+	}
+	return nil
+}
+
 type EnforcementLevel int32
 
 const (
 	EnforcementLevel_Advisory  EnforcementLevel = 0 // Displayed to users, but does not block deployment.
 	EnforcementLevel_Mandatory EnforcementLevel = 1 // Stops deployment, cannot be overridden.
 	EnforcementLevel_Disabled  EnforcementLevel = 2 // Disabled policies do not run during a deployment.
+	EnforcementLevel_Remediate EnforcementLevel = 3 // Remediated policies actually fix problems instead of issuing diagnostics.
 )
 
 type PolicyConfigJSONSchemaTypes []PolicyConfigJSONSchemaType
@@ -99,259 +105,146 @@ type PolicyConfigSchema struct {
 	Required []string `json:"required"`
 }
 
+// OnUnknown controls how a resource policy handles a resource whose properties contain unknown
+// (not-yet-computed) values, as happens during a preview of a resource that depends on another
+// not yet created. It only applies to ResourceValidationPolicy and ProviderValidationPolicy;
+// other ValidationPolicy kinds ignore it.
+type OnUnknown int32
+
+const (
+	// OnUnknownPassthrough runs the policy normally, unknown values and all -- the default, and
+	// the behavior every policy had before OnUnknown existed.
+	OnUnknownPassthrough OnUnknown = 0
+	// OnUnknownSkip skips running the policy entirely for a resource with unknown properties,
+	// reporting a "skipped" diagnostic (tagged "skipped=true") in its place instead of a violation.
+	OnUnknownSkip OnUnknown = 1
+	// OnUnknownFail reports a violation -- at the policy's own EnforcementLevel -- for a resource
+	// with unknown properties, instead of running the policy against values it can't meaningfully
+	// validate yet.
+	OnUnknownFail OnUnknown = 2
+)
+
 type Policy[T ValidationPolicy] struct {
 	Name             string
 	Description      string
 	EnforcementLevel EnforcementLevel
 	ConfigSchema     *PolicyConfigSchema
 	ValidationPolicy func() T
+
+	// OnUnknown controls how this policy handles a resource with unknown properties during
+	// preview. Only meaningful for a ResourceValidationPolicy or ProviderValidationPolicy; see
+	// OnUnknown's docs.
+	OnUnknown OnUnknown
+
+	// TargetURNs, if non-empty, restricts this policy to resources whose URN matches at least one
+	// of these glob patterns (e.g. "urn:pulumi:prod::*"), evaluated on the analyzer host before
+	// the policy ever runs -- so a rule that only cares about a subset of resources doesn't need
+	// an "if" at the top of its own body to get there. Only meaningful for a
+	// ResourceValidationPolicy or ProviderValidationPolicy.
+	TargetURNs []string
+
+	// ResourceTypes, if non-empty, restricts this policy to resources whose type token matches at
+	// least one of these glob patterns (e.g. "aws:s3/*"). Only meaningful for a
+	// ResourceValidationPolicy or ProviderValidationPolicy.
+	ResourceTypes []string
+
+	// Providers, if non-empty, restricts this policy to resources managed by a provider whose
+	// type token matches at least one of these glob patterns (e.g. "pulumi:providers:aws"). Only
+	// meaningful for a ResourceValidationPolicy or ProviderValidationPolicy.
+	Providers []string
+
+	// AppliesToStackTags, if non-empty, restricts this policy to stacks whose tags match every
+	// entry here. A value may be an exact match ("prod"), a glob ("prod-*"), or a negation
+	// ("!staging"); a stack with no value at all for a given key never matches a non-negated
+	// entry, and always matches a negated one. A stack that
+	// doesn't match is skipped rather than evaluated, with a "skipped=true" diagnostic in its
+	// place. See matchesStackTags.
+	//
+	// The analyzer protocol this SDK targets does not carry a stack's tags over the wire (see
+	// PolicyContext.Tags), so this can only be exercised today against a PolicyContext built by
+	// hand, e.g. via Harness.WithContext in a test; against a real engine-driven run,
+	// PolicyContext.Tags is always empty, so any non-negated entry here never matches.
+	AppliesToStackTags map[string]string
 }
 
 type ResourceValidationArgs struct {
 	Resource *pulumirpc.AnalyzerResource
-}
 
-type StackValidationArgs struct {
-	Resources []*pulumirpc.AnalyzerResource
-}
-
-type ReportViolation func(message string, urn string)
+	// config holds this policy's configuration, merged with any defaults declared in its
+	// ConfigSchema. Read it with GetConfig.
+	config map[string]interface{}
 
-type StackValidationPolicy func(args StackValidationArgs, reportViolation ReportViolation)
+	// policyContext holds the ambient stack context for this invocation. Read it with GetContext.
+	policyContext *PolicyContext
 
-type ResourceValidationPolicy func(args ResourceValidationArgs, reportViolation ReportViolation)
-
-type ValidationPolicy interface {
-	StackValidationPolicy | ResourceValidationPolicy
+	// reportViolation is the sink ReportViolationV2 forwards structured Violations to.
+	reportViolation ReportViolationV2
 }
-type Policies[T ValidationPolicy] []Policy[T]
 
-func Run(main func(config *config.Config) error) error {
-	// Make up the config for this policy project
-	project := os.Getenv("PULUMI_PROJECT")
-	config := config.New(nil, project)
-	return main(config)
+// GetConfig returns this policy's configuration, as supplied via `pulumi up --policy-pack-config`
+// (or the pack's per-policy defaults, for any property the caller didn't override).
+func (args ResourceValidationArgs) GetConfig() map[string]interface{} {
+	return args.config
 }
 
-var policyPackNameRE = regexp.MustCompile(`^[a-zA-Z0-9-_.]{1,100}$`)
-
-func Pack[T ValidationPolicy](name string, policies Policies[T]) error {
-	if name == "" || !policyPackNameRE.MatchString(name) {
-		logger.V(1).Infof("Invalid policy pack name %q. Policy pack names may only contain alphanumerics, hyphens, "+
-			"underscores, or periods.", name)
-		return fmt.Errorf("invalid policy pack name: %q", name)
-	}
-
-	for _, policy := range policies {
-		if policy.Name == "all" {
-			return fmt.Errorf("invalid policy name %[1]q. %[1]q is a reserved name", policy.Name)
-		}
-
-		if policy.ConfigSchema != nil {
-			if _, ok := policy.ConfigSchema.Properties["enforcementLevel"]; ok {
-				return errors.New("enforcementLevel cannot be explicitly specified in configSchema properties")
-			}
-			for _, req := range policy.ConfigSchema.Required {
-				if req == "enforcementLevel" {
-					return errors.New("enforcementLevel cannot be required in configSchema")
-				}
-			}
-		}
-	}
+// GetConfigAs decodes this policy's configuration -- the same values GetConfig returns -- into
+// out, a pointer to a user-provided Go struct, using out's own "json" struct tags to drive the
+// decode. This is the typed alternative to reading GetConfig's map by hand with type assertions.
+func (args ResourceValidationArgs) GetConfigAs(out interface{}) error {
+	return decodeConfig(args.config, out)
+}
 
-	// Fire up a gRPC server, letting the kernel choose a free port for us.
-	port, done, err := rpcutil.Serve(0, nil, []func(*grpc.Server) error{
-		func(srv *grpc.Server) error {
-			analyzer := &analyzerServer[T]{
-				policyPackName: name,
-				policies:       policies,
-			}
-			pulumirpc.RegisterAnalyzerServer(srv, analyzer)
-			return nil
-		},
-	}, nil)
-	if err != nil {
-		return fmt.Errorf("fatal: %v", err)
-	}
+type StackValidationArgs struct {
+	Resources []*pulumirpc.AnalyzerResource
 
-	// The analyzer protocol requires that we now write out the port we have chosen to listen on.
-	fmt.Printf("%d\n", port)
+	// config holds this policy's configuration, merged with any defaults declared in its
+	// ConfigSchema. Read it with GetConfig.
+	config map[string]interface{}
 
-	// Finally, wait for the server to stop serving.
-	if err := <-done; err != nil {
-		return fmt.Errorf("fatal: %v", err)
-	}
+	// policyContext holds the ambient stack context for this invocation. Read it with GetContext.
+	policyContext *PolicyContext
 
-	return nil
+	// reportViolation is the sink ReportViolationV2 forwards structured Violations to.
+	reportViolation ReportViolationV2
 }
 
-type analyzerServer[T ValidationPolicy] struct {
-	analyzer         plugin.Analyzer
-	policyPackName   string
-	policies         Policies[T]
-	policyPackConfig map[string]interface{}
+// GetConfig returns this policy's configuration, as supplied via `pulumi up --policy-pack-config`
+// (or the pack's per-policy defaults, for any property the caller didn't override).
+func (args StackValidationArgs) GetConfig() map[string]interface{} {
+	return args.config
 }
 
-func (a *analyzerServer[T]) Analyze(ctx context.Context, req *pulumirpc.AnalyzeRequest) (*pulumirpc.AnalyzeResponse, error) {
-	switch v := any(a).(type) {
-	case *analyzerServer[ResourceValidationPolicy]:
-		var ds []*pulumirpc.AnalyzeDiagnostic
-		for _, p := range a.policies {
-			defaultReportViolation := func(message string, urn string) {
-				violationMessage := p.Description
-				if message != "" {
-					violationMessage += fmt.Sprintf("\n%s", message)
-				}
-
-				ds = append(ds, &pulumirpc.AnalyzeDiagnostic{
-					PolicyName:       p.Name,
-					PolicyPackName:   a.policyPackName,
-					Description:      p.Description,
-					Message:          violationMessage,
-					EnforcementLevel: pulumirpc.EnforcementLevel(p.EnforcementLevel),
-					Urn:              urn,
-				})
-			}
-			args := ResourceValidationArgs{
-				Resource: &pulumirpc.AnalyzerResource{
-					Type:                 req.GetType(),
-					Properties:           req.GetProperties(),
-					Urn:                  req.GetUrn(),
-					Name:                 req.GetName(),
-					Options:              req.GetOptions(),
-					Provider:             req.GetProvider(),
-					Parent:               "",  /* TODO */
-					Dependencies:         nil, /* TODO */
-					PropertyDependencies: nil, /* TODO */
-				},
-			}
-			switch f := any(p.ValidationPolicy).(type) {
-			case func() ResourceValidationPolicy:
-				_, _ = fmt.Fprintf(os.Stderr, "Calling resource validation policy: %q on URN: %q\n", p.Name, req.GetUrn())
-				f()(args, defaultReportViolation)
-			default:
-				contract.Fail()
-			}
-		}
-		return &pulumirpc.AnalyzeResponse{
-			Diagnostics: ds,
-		}, nil
-	default:
-		return nil, fmt.Errorf("analyze unexpected on stack validation policypack: %q type: %T", a.policyPackName, v)
-	}
+// GetConfigAs decodes this policy's configuration -- the same values GetConfig returns -- into
+// out, a pointer to a user-provided Go struct, using out's own "json" struct tags to drive the
+// decode. This is the typed alternative to reading GetConfig's map by hand with type assertions.
+func (args StackValidationArgs) GetConfigAs(out interface{}) error {
+	return decodeConfig(args.config, out)
 }
 
-func (a *analyzerServer[T]) AnalyzeStack(ctx context.Context, req *pulumirpc.AnalyzeStackRequest) (*pulumirpc.
-	AnalyzeResponse,
-	error) {
-	switch any(a).(type) {
-	case *analyzerServer[StackValidationPolicy]:
-		var ds []*pulumirpc.AnalyzeDiagnostic
-		for _, p := range a.policies {
-			defaultReportViolation := func(message string, urn string) {
-				violationMessage := p.Description
-				if message != "" {
-					violationMessage += fmt.Sprintf("\n%s", message)
-				}
-
-				ds = append(ds, &pulumirpc.AnalyzeDiagnostic{
-					PolicyName:       p.Name,
-					PolicyPackName:   a.policyPackName,
-					Description:      p.Description,
-					Message:          violationMessage,
-					EnforcementLevel: pulumirpc.EnforcementLevel(p.EnforcementLevel),
-					Urn:              urn,
-				})
-			}
-
-			var resources []*pulumirpc.AnalyzerResource
-			for _, r := range req.GetResources() {
-				resources = append(resources, &pulumirpc.AnalyzerResource{
-					Type:                 r.GetType(),
-					Properties:           r.GetProperties(),
-					Urn:                  r.GetUrn(),
-					Name:                 r.GetName(),
-					Options:              r.GetOptions(),
-					Provider:             r.GetProvider(),
-					Parent:               r.GetParent(),
-					Dependencies:         r.GetDependencies(),
-					PropertyDependencies: r.GetPropertyDependencies(),
-				})
-			}
-			args := StackValidationArgs{
-				Resources: resources,
-			}
-			switch f := any(p.ValidationPolicy).(type) {
-			case func() StackValidationPolicy:
-				f()(args, defaultReportViolation)
-			default:
-				contract.Fail()
-			}
-		}
-		return &pulumirpc.AnalyzeResponse{
-			Diagnostics: ds,
-		}, nil
-	default:
-		// Ignore since we seem to call analyze stack regardless.
-		return &pulumirpc.AnalyzeResponse{}, nil
-	}
+type ReportViolation func(message string, urn string)
 
-}
+type StackValidationPolicy func(args StackValidationArgs, reportViolation ReportViolation)
 
-func (a *analyzerServer[T]) GetAnalyzerInfo(context.Context, *pbempty.Empty) (*pulumirpc.AnalyzerInfo, error) {
-	var policies []*pulumirpc.PolicyInfo
-
-	for _, p := range a.policies {
-		var required []string
-		configSchemaProps := resource.NewPropertyMapFromMap(nil)
-		if p.ConfigSchema != nil {
-			configSchemaProps = resource.NewPropertyMap(p.ConfigSchema.Properties)
-			required = p.ConfigSchema.Required
-		}
-		props, err := plugin.MarshalProperties(configSchemaProps,
-			plugin.MarshalOptions{KeepSecrets: true})
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal properties for policy pack: %q: %w", a.policyPackName, err)
-		}
-		configSchema := pulumirpc.PolicyConfigSchema{
-			Properties: props,
-			Required:   required,
-		}
-
-		policies = append(policies, &pulumirpc.PolicyInfo{
-			Name:             p.Name,
-			Description:      p.Description,
-			EnforcementLevel: pulumirpc.EnforcementLevel(p.EnforcementLevel),
-			ConfigSchema:     &configSchema,
-		})
-	}
-	return &pulumirpc.AnalyzerInfo{
-		Name:           a.policyPackName,
-		Policies:       policies,
-		SupportsConfig: true,
-		InitialConfig:  nil, /* TODO */
-	}, nil
-}
+type ResourceValidationPolicy func(args ResourceValidationArgs, reportViolation ReportViolation)
 
-func (a *analyzerServer[T]) GetPluginInfo(context.Context, *pbempty.Empty) (*pulumirpc.PluginInfo, error) {
-	return &pulumirpc.PluginInfo{
-		Version: version.Version,
-	}, nil
+type ValidationPolicy interface {
+	StackValidationPolicy | ResourceValidationPolicy | ResourceRemediationPolicy | ProviderValidationPolicy
 }
+type Policies[T ValidationPolicy] []Policy[T]
 
-func (a *analyzerServer[T]) Configure(ctx context.Context, req *pulumirpc.ConfigureAnalyzerRequest) (*pbempty.Empty,
-	error) {
-	conf := map[string]interface{}{}
-	for k, v := range req.PolicyConfig {
-		pm, err := plugin.UnmarshalProperties(v.GetProperties(), plugin.MarshalOptions{
-			Label:        fmt.Sprintf("%s.configure", a.policyPackName),
-			KeepUnknowns: true,
-		})
-		conf[k] = pm.Mappable()
-		if err != nil {
-			return nil, err
-		}
-	}
-	a.policyPackConfig = conf
-	return &pbempty.Empty{}, nil
+// Run is the entrypoint for a policy pack's program. It hands main a config.Config namespaced to
+// the pack's project, as named by the PULUMI_PROJECT environment variable.
+//
+// Note that unlike a Pulumi program, a policy pack runs as a standalone analyzer plugin: the
+// engine never starts it with the PULUMI_CONFIG environment variable a *pulumi.Context reads
+// stack configuration from, so config.Get and friends will not find values here today. Per-policy
+// configuration declared in a Policy's ConfigSchema -- available via ResourceValidationArgs.
+// GetConfig and StackValidationArgs.GetConfig -- is the supported way to make a policy
+// configurable; see PolicyContext for the other ambient stack context available inside a policy
+// callback, of which only Project is populated.
+func Run(main func(config *config.Config) error) error {
+	// Make up the config for this policy project
+	project := os.Getenv("PULUMI_PROJECT")
+	config := config.New(nil, project)
+	return main(config)
 }
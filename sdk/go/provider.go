@@ -0,0 +1,18 @@
+package policy
+
+import "strings"
+
+// ProviderValidationPolicy validates a provider resource's (e.g. "pulumi:providers:aws") pending
+// configuration before any resource it manages is checked, catching things like an unpinned
+// version, a disallowed region, or a plaintext credential at the source instead of in every
+// resource that happens to use that provider. It receives the same ResourceValidationArgs a
+// ResourceValidationPolicy does -- the provider's configuration is just that resource's
+// properties -- and reports violations the same way. Register one with
+// PackBuilder.AddProviderPolicy.
+type ProviderValidationPolicy func(args ResourceValidationArgs, reportViolation ReportViolation)
+
+// isProviderType reports whether resourceType names a provider resource, e.g.
+// "pulumi:providers:aws", rather than one of the resources that provider manages.
+func isProviderType(resourceType string) bool {
+	return strings.HasPrefix(resourceType, "pulumi:providers:")
+}
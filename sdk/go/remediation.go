@@ -0,0 +1,191 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// ResourceRemediationPolicy mutates a resource's properties before they are checked against other
+// policies in the pack, returning the property map to apply. A nil result (with a nil error)
+// leaves the resource unchanged. This lets a policy auto-fix a problem -- e.g. defaulting a
+// missing "CostCenter" tag to "unknown" -- rather than only reporting it as a violation.
+type ResourceRemediationPolicy func(args ResourceValidationArgs) (resource.PropertyMap, error)
+
+func (a *analyzerServer) Remediate(ctx context.Context, req *pulumirpc.AnalyzeRequest) (
+	*pulumirpc.RemediateResponse, error,
+) {
+	props, err := plugin.UnmarshalProperties(req.GetProperties(), plugin.MarshalOptions{
+		KeepUnknowns: true,
+		KeepSecrets:  true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling properties for %q: %w", req.GetUrn(), err)
+	}
+
+	// A resource with unknown (not-yet-computed) properties is being previewed, not deployed: a
+	// remediation policy mutating an unknown property couldn't compute a sensible replacement
+	// value anyway, so skip remediating this resource entirely rather than letting a policy author
+	// either crash or silently discard the unknown-ness of a value they can't yet see.
+	if props.ContainsUnknowns() {
+		return &pulumirpc.RemediateResponse{}, nil
+	}
+
+	var remediations []*pulumirpc.Remediation
+	for _, p := range a.remediations {
+		args := ResourceValidationArgs{
+			Resource: &pulumirpc.AnalyzerResource{
+				Type:       req.GetType(),
+				Properties: req.GetProperties(),
+				Urn:        req.GetUrn(),
+				Name:       req.GetName(),
+				Options:    req.GetOptions(),
+				Provider:   req.GetProvider(),
+			},
+			config:        p.ConfigSchema.withDefaults(a.configFor(p.Name)),
+			policyContext: a.policyContext,
+		}
+
+		newProps, err := p.ValidationPolicy()(args)
+		if err != nil {
+			remediations = append(remediations, &pulumirpc.Remediation{
+				PolicyName:     p.Name,
+				PolicyPackName: a.policyPackName,
+				Description:    p.Description,
+				Diagnostic:     err.Error(),
+			})
+			continue
+		}
+		if newProps == nil {
+			continue
+		}
+
+		diff := describePropertyDiff(props, newProps)
+		if diff == "" {
+			continue
+		}
+
+		marshaled, err := plugin.MarshalProperties(newProps, plugin.MarshalOptions{
+			KeepUnknowns: true,
+			KeepSecrets:  true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("marshaling remediated properties for %q: %w", req.GetUrn(), err)
+		}
+
+		remediations = append(remediations, &pulumirpc.Remediation{
+			PolicyName:     p.Name,
+			PolicyPackName: a.policyPackName,
+			Description:    p.Description,
+			Properties:     marshaled,
+			Diagnostic:     diff,
+		})
+
+		// Subsequent remediation policies in the pack, and the validation calls that follow, see
+		// the property set as remediated so far.
+		props = newProps
+		req.Properties = marshaled
+	}
+
+	return &pulumirpc.RemediateResponse{Remediations: remediations}, nil
+}
+
+// MergeProperties returns a copy of base with every key in patch overlaid on top, recursing into
+// nested objects so that a remediation can patch a single nested field without clobbering its
+// other sibling fields -- e.g. patching just "tags.CostCenter" leaves every other tag alone. If
+// base's value for a key is secret, the merged result stays secret even if patch's value for that
+// key isn't marked secret itself, so a remediation can't accidentally downgrade a secret to
+// plaintext just by patching over it.
+func MergeProperties(base, patch resource.PropertyMap) resource.PropertyMap {
+	merged := make(resource.PropertyMap, len(base)+len(patch))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		if existing, ok := merged[k]; ok {
+			v = mergePropertyValue(existing, v)
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+func mergePropertyValue(existing, patch resource.PropertyValue) resource.PropertyValue {
+	wasSecret := existing.IsSecret()
+	if wasSecret {
+		existing = existing.SecretValue().Element
+	}
+	if patch.IsSecret() {
+		patch = patch.SecretValue().Element
+	}
+
+	result := patch
+	if existing.IsObject() && patch.IsObject() {
+		result = resource.NewObjectProperty(MergeProperties(existing.ObjectValue(), patch.ObjectValue()))
+	}
+	if wasSecret {
+		result = resource.MakeSecret(result)
+	}
+	return result
+}
+
+// WithDefault returns a copy of props with key set to value, but only if props doesn't already
+// have a non-null value for key. This is the common shape of a remediation that only fills in a
+// gap rather than overriding what's already there, e.g. "any RandomUuid missing keepers gets
+// keepers = {enforced: <urn>} injected".
+func WithDefault(props resource.PropertyMap, key resource.PropertyKey, value interface{}) resource.PropertyMap {
+	if existing, ok := props[key]; ok && !existing.IsNull() {
+		return props
+	}
+	return MergeProperties(props, resource.PropertyMap{
+		key: resource.NewPropertyValue(value),
+	})
+}
+
+// describePropertyDiff summarizes the top-level properties a remediation added, removed, or
+// changed, e.g. "+costCenter: unknown"; a change nested inside an object is reported as a change
+// to its top-level key, e.g. "~tags: ... -> ...". Returns "" if before and after are equivalent.
+func describePropertyDiff(before, after resource.PropertyMap) string {
+	diff := before.Diff(after)
+	if diff == nil || !diff.AnyChanges() {
+		return ""
+	}
+
+	var keys []resource.PropertyKey
+	for k := range diff.Adds {
+		keys = append(keys, k)
+	}
+	for k := range diff.Updates {
+		keys = append(keys, k)
+	}
+	for k := range diff.Deletes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var lines []string
+	for _, k := range keys {
+		switch {
+		case diff.Added(k):
+			lines = append(lines, fmt.Sprintf("+%s: %v", k, after[k]))
+		case diff.Deleted(k):
+			lines = append(lines, fmt.Sprintf("-%s: %v", k, before[k]))
+		case diff.Updated(k):
+			u := diff.Updates[k]
+			lines = append(lines, fmt.Sprintf("~%s: %v -> %v", k, u.Old, u.New))
+		}
+	}
+
+	summary := ""
+	for i, l := range lines {
+		if i > 0 {
+			summary += ", "
+		}
+		summary += l
+	}
+	return summary
+}
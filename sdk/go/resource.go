@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// As decodes a resource's properties into a user-provided Go type T, using T's "pulumi" struct
+// tags to drive the decode (falling back to "json", then the field's own name). It saves policy
+// authors from reaching into args.Resource.Properties.GetFields() and calling
+// GetNumberValue/GetStringValue by hand for every property they care about. See
+// ResourceValidationArgs.As for the non-generic, decode-into-an-existing-value form of this.
+func As[T any](args ResourceValidationArgs) (T, error) {
+	var out T
+	if err := args.As(&out); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+// As decodes this resource's properties into out, a pointer to a user-provided Go struct, using
+// its "pulumi" struct tags to drive the decode (falling back to "json", then the field's own
+// name). A secret property's underlying value is unwrapped transparently; a property that is
+// still computed (not yet known, as during a preview of a resource that depends on one not yet
+// created) decodes as its field's zero value rather than failing the decode; an asset or archive
+// property decodes into a resource.Asset/resource.Archive field directly, or into a string (its
+// URI or hash) for any other field type.
+func (args ResourceValidationArgs) As(out interface{}) error {
+	props, err := plugin.UnmarshalProperties(args.Resource.GetProperties(), plugin.MarshalOptions{
+		KeepUnknowns: true,
+		KeepSecrets:  true,
+	})
+	if err != nil {
+		return fmt.Errorf("unmarshaling properties for %q: %w", args.Resource.GetUrn(), err)
+	}
+	if err := decodeResource(props, out); err != nil {
+		return fmt.Errorf("decoding properties for %q into %T: %w", args.Resource.GetUrn(), out, err)
+	}
+	return nil
+}
+
+// FindResource returns the resource in the stack with the given URN, if any.
+func (args StackValidationArgs) FindResource(urn string) (*pulumirpc.AnalyzerResource, bool) {
+	for _, r := range args.Resources {
+		if r.GetUrn() == urn {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// Aliases returns the fully-resolved former URNs the engine has aliased r from -- the same list
+// a "pulumi up" that renames or re-parents a resource sends so the engine treats prior state as
+// this resource rather than replacing it. This lets a policy recognize a resource across a
+// rename, e.g. to grandfather in a resource that used to be named or parented differently.
+func Aliases(r *pulumirpc.AnalyzerResource) []string {
+	return r.GetOptions().GetAliases()
+}
+
+// Aliases returns the fully-resolved former URNs the engine has aliased this resource from.
+func (args ResourceValidationArgs) Aliases() []string {
+	return Aliases(args.Resource)
+}
+
+// FindResourceByURNOrAlias returns the resource in the stack whose current URN, or any of whose
+// Aliases, matches urn.
+func (args StackValidationArgs) FindResourceByURNOrAlias(urn string) (*pulumirpc.AnalyzerResource, bool) {
+	if r, ok := args.FindResource(urn); ok {
+		return r, true
+	}
+	for _, r := range args.Resources {
+		for _, alias := range Aliases(r) {
+			if alias == urn {
+				return r, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Dependencies returns the transitive set of resources that the resource with the given URN
+// depends on, following Dependencies edges until no new resources are discovered.
+func (args StackValidationArgs) Dependencies(urn string) []*pulumirpc.AnalyzerResource {
+	byURN := make(map[string]*pulumirpc.AnalyzerResource, len(args.Resources))
+	for _, r := range args.Resources {
+		byURN[r.GetUrn()] = r
+	}
+
+	seen := map[string]bool{urn: true}
+	queue := []string{urn}
+	var deps []*pulumirpc.AnalyzerResource
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		r, ok := byURN[cur]
+		if !ok {
+			continue
+		}
+		for _, dep := range r.GetDependencies() {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			if depResource, ok := byURN[dep]; ok {
+				deps = append(deps, depResource)
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return deps
+}
@@ -0,0 +1,24 @@
+package policy
+
+// matchesResourceSelectors reports whether a resource with the given urn, resourceType, and
+// providerType (the type token of the provider managing it, or "" if none) should be evaluated by
+// a policy declaring targetURNs, resourceTypes, and providers as its TargetURNs/ResourceTypes/
+// Providers selectors. An empty selector list always matches; a non-empty one matches if the
+// corresponding value matches at least one of its glob patterns.
+func matchesResourceSelectors(targetURNs, resourceTypes, providers []string, urn, resourceType, providerType string) bool {
+	return matchesAnyGlob(targetURNs, urn) &&
+		matchesAnyGlob(resourceTypes, resourceType) &&
+		matchesAnyGlob(providers, providerType)
+}
+
+func matchesAnyGlob(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if globMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
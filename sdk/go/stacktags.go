@@ -0,0 +1,62 @@
+package policy
+
+import (
+	"regexp"
+	"strings"
+)
+
+// matchesStackTags reports whether stackTags satisfies every constraint in want. Each value in
+// want is matched against the stack's value for the same key: a leading "!" negates the match
+// (the stack must NOT have that value, including not having the tag at all), and the remainder is
+// otherwise matched with globMatch, so "prod-*" matches "prod-us-east" but not a bare "prod". A key
+// in want that the stack has no tag for never matches a non-negated pattern.
+func matchesStackTags(want map[string]string, stackTags map[string]string) bool {
+	for key, pattern := range want {
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
+		}
+
+		have, ok := stackTags[key]
+		matched := ok && globMatch(pattern, have)
+
+		if negate {
+			matched = !matched
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch reports whether value matches pattern, where "*" matches any run of characters and "?"
+// matches exactly one, every other character matching itself literally. Unlike path.Match, "*"
+// here is not stopped by "/": the values this package matches against -- Pulumi URNs and type
+// tokens -- routinely contain "/" as an ordinary character (e.g. "aws:s3/bucket:Bucket"), and
+// path.Match's shell-glob semantics would silently fail to match a pattern spanning one, such as
+// the documented TargetURNs example "urn:pulumi:prod::*" against a real URN.
+func globMatch(pattern, value string) bool {
+	re, err := regexp.Compile("^" + globToRegexp(pattern) + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
+
+// globToRegexp translates a glob pattern ("*"/"?" wildcards, everything else literal) into the
+// equivalent regexp source, anchors added by the caller.
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
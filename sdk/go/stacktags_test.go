@@ -0,0 +1,26 @@
+package policy
+
+import "testing"
+
+// TestGlobMatchAcrossSlash guards against the path.Match regression this replaced: path.Match's
+// "*" never crosses a "/", but Pulumi type tokens and URNs routinely contain one.
+func TestGlobMatchAcrossSlash(t *testing.T) {
+	if !globMatch("urn:pulumi:prod::*", "urn:pulumi:prod::myproj::aws:s3/bucket:Bucket::mybucket") {
+		t.Fatal("expected the documented TargetURNs example to match a URN containing a \"/\"")
+	}
+	if !globMatch("aws:s3/*", "aws:s3/bucket:Bucket") {
+		t.Fatal("expected a glob to match a type token with \"/\" immediately after the wildcard")
+	}
+	if globMatch("aws:s3/*", "aws:ec2/instance:Instance") {
+		t.Fatal("expected a glob not to match a differing prefix")
+	}
+}
+
+func TestMatchesResourceSelectorsAcrossSlash(t *testing.T) {
+	if !matchesResourceSelectors(
+		[]string{"urn:pulumi:prod::*"}, nil, nil,
+		"urn:pulumi:prod::myproj::aws:s3/bucket:Bucket::mybucket", "aws:s3/bucket:Bucket", "",
+	) {
+		t.Fatal("expected a TargetURNs selector to match a resource URN containing a \"/\"")
+	}
+}
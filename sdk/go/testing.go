@@ -0,0 +1,198 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pbempty "github.com/golang/protobuf/ptypes/empty"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// UnknownValue is a sentinel a TestResource's Properties map can use for a property whose value
+// won't be known until after a preview completes, reproducing the "unknown during preview"
+// behavior a real deployment can produce without needing a cloud backend.
+type UnknownValue struct{}
+
+// Unknown marks a TestResource property as not yet known, the way it would appear during a
+// preview of a resource whose value depends on another resource not yet created.
+var Unknown = UnknownValue{}
+
+// TestResource is a synthetic resource a test feeds to a Harness, standing in for the
+// AnalyzerResource the engine would otherwise send over the wire.
+type TestResource struct {
+	Type                 string
+	Name                 string
+	URN                  string
+	Properties           map[string]interface{}
+	Options              *pulumirpc.AnalyzerResourceOptions
+	Provider             *pulumirpc.AnalyzerProviderResource
+	Parent               string
+	Dependencies         []string
+	PropertyDependencies map[string]*pulumirpc.AnalyzerPropertyDependencies
+}
+
+func (r TestResource) urn() string {
+	if r.URN != "" {
+		return r.URN
+	}
+	return fmt.Sprintf("urn:pulumi:stack::project::%s::%s", r.Type, r.Name)
+}
+
+// propertyMap converts Properties into a resource.PropertyMap, substituting Unknown sentinels for
+// the engine's own computed-value representation.
+func (r TestResource) propertyMap() resource.PropertyMap {
+	raw := make(map[string]interface{}, len(r.Properties))
+	var unknownKeys []string
+	for k, v := range r.Properties {
+		if _, ok := v.(UnknownValue); ok {
+			unknownKeys = append(unknownKeys, k)
+			continue
+		}
+		raw[k] = v
+	}
+	pm := resource.NewPropertyMapFromMap(raw)
+	for _, k := range unknownKeys {
+		pm[resource.PropertyKey(k)] = resource.MakeComputed(resource.NewStringProperty(""))
+	}
+	return pm
+}
+
+func (r TestResource) marshalProperties() (*pulumirpc.AnalyzeRequest, error) {
+	props, err := plugin.MarshalProperties(r.propertyMap(), plugin.MarshalOptions{
+		KeepUnknowns: true,
+		KeepSecrets:  true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling properties for %q: %w", r.urn(), err)
+	}
+	return &pulumirpc.AnalyzeRequest{
+		Type:       r.Type,
+		Properties: props,
+		Urn:        r.urn(),
+		Name:       r.Name,
+		Options:    r.Options,
+		Provider:   r.Provider,
+	}, nil
+}
+
+// Harness runs a policy pack's policies directly in-process, without serving them over the
+// analyzer gRPC protocol, so a pack author can unit test their rules against synthetic resources.
+type Harness struct {
+	analyzer *analyzerServer
+}
+
+// Harness returns a Harness for unit-testing the policies assembled in this PackBuilder.
+func (b *PackBuilder) Harness() *Harness {
+	return &Harness{
+		analyzer: &analyzerServer{
+			policyPackName:   b.name,
+			resourcePolicies: b.resourcePolicies,
+			stackPolicies:    b.stackPolicies,
+			remediations:     b.remediations,
+			providerPolicies: b.providerPolicies,
+			policyContext:    &PolicyContext{},
+		},
+	}
+}
+
+// WithContext sets the PolicyContext (project, stack, dry run, ...) policies will see for the
+// rest of this Harness's calls.
+func (h *Harness) WithContext(ctx PolicyContext) *Harness {
+	h.analyzer.policyContext = &ctx
+	return h
+}
+
+// Configure supplies per-policy configuration, keyed by policy name, validating it against each
+// policy's ConfigSchema exactly as the real Configure RPC would.
+func (h *Harness) Configure(config map[string]map[string]interface{}) error {
+	policyConfig := make(map[string]*pulumirpc.PolicyConfig, len(config))
+	for name, conf := range config {
+		props, err := plugin.MarshalProperties(resource.NewPropertyMapFromMap(conf), plugin.MarshalOptions{KeepSecrets: true})
+		if err != nil {
+			return fmt.Errorf("marshaling config for policy %q: %w", name, err)
+		}
+		policyConfig[name] = &pulumirpc.PolicyConfig{Properties: props}
+	}
+	_, err := h.analyzer.Configure(context.Background(), &pulumirpc.ConfigureAnalyzerRequest{PolicyConfig: policyConfig})
+	return err
+}
+
+// GetAnalyzerInfo returns the pack's PolicyInfo and InitialConfig the same way the engine's
+// GetAnalyzerInfo RPC would, letting a test assert on a policy's declared ConfigSchema and its
+// computed defaults without serving the analyzer protocol.
+func (h *Harness) GetAnalyzerInfo() (*pulumirpc.AnalyzerInfo, error) {
+	return h.analyzer.GetAnalyzerInfo(context.Background(), &pbempty.Empty{})
+}
+
+// ValidateConfig checks config, keyed by policy name, against each policy's ConfigSchema, the same
+// way Configure would, but without storing it for use by a later AnalyzeResource/AnalyzeStack call.
+// This lets a scenario like "Required config property not set" assert on a config error directly,
+// without provisioning any resources.
+func (h *Harness) ValidateConfig(config map[string]map[string]interface{}) error {
+	conf := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		conf[k] = v
+	}
+	if violations := h.analyzer.validateConfig(conf); len(violations) > 0 {
+		return fmt.Errorf("validating policy config: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// AnalyzeResource runs every resource policy in the pack against r, the same way the engine's
+// Analyze RPC would for a single resource.
+func (h *Harness) AnalyzeResource(r TestResource) ([]*pulumirpc.AnalyzeDiagnostic, error) {
+	req, err := r.marshalProperties()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.analyzer.Analyze(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetDiagnostics(), nil
+}
+
+// AnalyzeStack runs every stack policy in the pack against resources, the same way the engine's
+// AnalyzeStack RPC would.
+func (h *Harness) AnalyzeStack(resources []TestResource) ([]*pulumirpc.AnalyzeDiagnostic, error) {
+	analyzerResources := make([]*pulumirpc.AnalyzerResource, len(resources))
+	for i, r := range resources {
+		props, err := plugin.MarshalProperties(r.propertyMap(), plugin.MarshalOptions{
+			KeepUnknowns: true,
+			KeepSecrets:  true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("marshaling properties for %q: %w", r.urn(), err)
+		}
+		analyzerResources[i] = &pulumirpc.AnalyzerResource{
+			Type:                 r.Type,
+			Properties:           props,
+			Urn:                  r.urn(),
+			Name:                 r.Name,
+			Options:              r.Options,
+			Provider:             r.Provider,
+			Parent:               r.Parent,
+			Dependencies:         r.Dependencies,
+			PropertyDependencies: r.PropertyDependencies,
+		}
+	}
+	resp, err := h.analyzer.AnalyzeStack(context.Background(), &pulumirpc.AnalyzeStackRequest{Resources: analyzerResources})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetDiagnostics(), nil
+}
+
+// Remediate runs every remediation policy in the pack against r, the same way the engine's
+// Remediate RPC would.
+func (h *Harness) Remediate(r TestResource) (*pulumirpc.RemediateResponse, error) {
+	req, err := r.marshalProperties()
+	if err != nil {
+		return nil, err
+	}
+	return h.analyzer.Remediate(context.Background(), req)
+}
@@ -0,0 +1,6 @@
+// Package version contains the version of this SDK.
+package version
+
+// Version is the semver of this build, set via `-ldflags "-X .../version.Version=vX.Y.Z"` by the
+// release build. Left empty for a local/dev build.
+var Version string
@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Violation is a single policy violation, with everything a policy author might want to attach
+// to it beyond the plain message/URN pair that ReportViolation accepts.
+type Violation struct {
+	// Message describes what's wrong, e.g. "bucket is missing server-side encryption".
+	Message string
+	// URN is the resource the violation applies to. For a resource policy, defaults to the
+	// resource being analyzed if left empty; for a stack policy, an empty URN is reported as is.
+	URN string
+	// PropertyPath is a dotted path into the resource's properties pinpointing the offending
+	// value, e.g. "spec.containers[0].image".
+	PropertyPath string
+	// EnforcementLevel overrides the policy's own enforcement level for this violation only, e.g.
+	// to downgrade a specific, known-acceptable case to advisory. Leave nil to use the policy's
+	// configured level.
+	EnforcementLevel *EnforcementLevel
+	// RemediationSteps is human-readable guidance (or a URL to it) on how to fix the violation.
+	RemediationSteps string
+	// Metadata is machine-readable labels for the violation, e.g. {"cis-control": "2.1.1"} or
+	// {"cwe": "CWE-200"}.
+	Metadata map[string]string
+}
+
+// ReportViolationV2 reports a structured Violation. Unlike ReportViolation, it can target a
+// specific property path, override the policy's enforcement level for just this hit, and attach
+// remediation guidance and machine-readable metadata.
+type ReportViolationV2 func(violation Violation)
+
+// ReportViolationV2 reports a structured Violation for this resource.
+func (args ResourceValidationArgs) ReportViolationV2(violation Violation) {
+	args.reportViolation(violation)
+}
+
+// ReportViolationV2 reports a structured Violation for this stack.
+func (args StackValidationArgs) ReportViolationV2(violation Violation) {
+	args.reportViolation(violation)
+}
+
+// formatViolationMessage builds the AnalyzeDiagnostic.Message text for a violation, folding in
+// the PropertyPath and RemediationSteps fields the analyzer protocol has no dedicated slot for.
+func formatViolationMessage(description string, violation Violation) string {
+	message := description
+	if violation.Message != "" {
+		message += fmt.Sprintf("\n%s", violation.Message)
+	}
+	if violation.PropertyPath != "" {
+		message += fmt.Sprintf("\nProperty: %s", violation.PropertyPath)
+	}
+	if violation.RemediationSteps != "" {
+		message += fmt.Sprintf("\nRemediation: %s", violation.RemediationSteps)
+	}
+	return message
+}
+
+// violationTags renders a violation's Metadata as "key=value" tags, sorted by key so that output
+// is stable across runs. The analyzer protocol has no map field for arbitrary metadata, so tags
+// are the closest fit -- they're documented as "keywords/terms to associate with a policy".
+func violationTags(metadata map[string]string) []string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags := make([]string, 0, len(keys))
+	for _, k := range keys {
+		tags = append(tags, fmt.Sprintf("%s=%s", k, metadata[k]))
+	}
+	return tags
+}
+
+// resolveEnforcementLevel returns the violation's enforcement level override, if any, or the
+// policy's own configured level otherwise.
+func resolveEnforcementLevel(policyLevel EnforcementLevel, violation Violation) EnforcementLevel {
+	if violation.EnforcementLevel != nil {
+		return *violation.EnforcementLevel
+	}
+	return policyLevel
+}
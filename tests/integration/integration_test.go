@@ -21,12 +21,14 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	ptesting "github.com/pulumi/pulumi/sdk/v3/go/common/testing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 type Runtime int
@@ -34,8 +36,27 @@ type Runtime int
 const (
 	NodeJS Runtime = iota
 	Python
+	Go
+	Dotnet
 )
 
+// runtimeName returns the name runtime is known by across fixture directory naming
+// ("policy-pack-<name>") and t.Run sub-test names.
+func runtimeName(runtime Runtime) string {
+	switch runtime {
+	case NodeJS:
+		return "nodejs"
+	case Python:
+		return "python"
+	case Go:
+		return "go"
+	case Dotnet:
+		return "dotnet"
+	default:
+		return "unknown"
+	}
+}
+
 type PolicyConfig map[string]interface{}
 
 // policyTestScenario describes an iteration of the
@@ -46,6 +67,88 @@ type policyTestScenario struct {
 	Advisory bool
 	// The Policy Pack configuration to use for the test scenario.
 	PolicyPackConfig map[string]PolicyConfig
+	// ValidateConfigOnly runs "pulumi policy validate-config" against PolicyPackConfig instead of
+	// provisioning the program with "pulumi up", so a scenario that only cares about a config
+	// error -- e.g. "Required config property not set" -- doesn't need to wait on a deployment to
+	// see it.
+	ValidateConfigOnly bool
+	// PolicyPackConfigFiles names JSON or YAML fixture files, relative to the test directory, to
+	// pass as one "--policy-pack-config" flag each, in order, mirroring "pulumi policy enable
+	// --config <file>". Layering multiple files lets a scenario put environment-specific overrides
+	// in a later file on top of a base config in an earlier one. Mutually exclusive with
+	// PolicyPackConfig.
+	PolicyPackConfigFiles []string
+	// PolicyGroup names the Policy Group this scenario's pack is enabled in, mirroring "pulumi
+	// policy enable --policy-group <name>". Every runner in this file passes it through as a
+	// "--policy-group" flag; empty means no group.
+	PolicyGroup string
+	// WantSkipped is the set of "skipped=true"-tagged diagnostic messages we expect to see in the
+	// command's output, for a policy with OnUnknownSkip that declined to run against a resource
+	// with unknown properties during preview, rather than the policy failing outright.
+	WantSkipped []string
+	// MaxDuration, if nonzero, fails the scenario if its "pulumi" invocation takes longer than
+	// this, catching a resource-validation policy that's become accidentally slow (e.g. O(n^2)
+	// over stack size) before it regresses further.
+	MaxDuration time.Duration
+	// WantSkippedPolicies is the set of policy names we expect to see a "skipped=true"-tagged
+	// "stack tags do not match appliesToStackTags" diagnostic for, proving a policy was excluded
+	// from this stack by tag mismatch rather than having silently passed. Requires the stack this
+	// scenario runs against to have been given tags, via runPolicyPackIntegrationTestWithStackTags's
+	// stackTags parameter.
+	WantSkippedPolicies []string
+}
+
+// policyPerfResult is one scenario's wall-clock timing, recorded into a PolicyPerfBenchmark
+// report for tracking regressions in CI.
+type policyPerfResult struct {
+	PolicyPack string        `json:"policyPack"`
+	Scenario   string        `json:"scenario"`
+	Duration   time.Duration `json:"durationNanos"`
+	MaxAllowed time.Duration `json:"maxAllowedNanos,omitempty"`
+}
+
+// PolicyPerfBenchmark accumulates per-scenario timing across a test run and writes it out as a
+// JSON report a CI job can diff against a previous run to catch performance regressions.
+type PolicyPerfBenchmark struct {
+	mu      sync.Mutex
+	results []policyPerfResult
+}
+
+func (b *PolicyPerfBenchmark) record(policyPack, scenario string, duration, maxAllowed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.results = append(b.results, policyPerfResult{
+		PolicyPack: policyPack,
+		Scenario:   scenario,
+		Duration:   duration,
+		MaxAllowed: maxAllowed,
+	})
+}
+
+// WriteReport marshals every result recorded so far to path as indented JSON.
+func (b *PolicyPerfBenchmark) WriteReport(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, err := json.MarshalIndent(b.results, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshaling policy perf report: %w", err)
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// policyPerfBenchmark accumulates timing across every policy pack integration test in this
+// package. Set POLICY_PERF_REPORT to a file path to have TestMain write it out as JSON once all
+// tests finish, for a CI job to diff against a previous run.
+var policyPerfBenchmark = &PolicyPerfBenchmark{}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if reportPath := os.Getenv("POLICY_PERF_REPORT"); reportPath != "" {
+		if err := policyPerfBenchmark.WriteReport(reportPath); err != nil {
+			fmt.Fprintf(os.Stderr, "writing policy perf report: %v\n", err)
+		}
+	}
+	os.Exit(code)
 }
 
 func pathEnvWith(path string) string {
@@ -56,6 +159,44 @@ func pathEnvWith(path string) string {
 	return "PATH=" + pathEnv + pathSeparator + path
 }
 
+// venvBinDir returns the directory a "python -m venv" environment places its python/pip
+// executables in, which differs between POSIX and Windows.
+func venvBinDir(venvDir string) string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(venvDir, "Scripts")
+	}
+	return filepath.Join(venvDir, "bin")
+}
+
+// pathEnvPrepending returns a PATH environment variable with dir searched before the existing
+// PATH, so that a virtualenv's python is found ahead of whatever python is already installed.
+func pathEnvPrepending(dir string) string {
+	pathEnv, pathSeparator := os.Getenv("PATH"), ":"
+	if runtime.GOOS == "windows" {
+		pathSeparator = ";"
+	}
+	return "PATH=" + dir + pathSeparator + pathEnv
+}
+
+// convertYAMLConfigToJSON reads the YAML policy pack config at yamlPath and writes it back out as
+// JSON alongside the original file, returning the JSON file's path. The engine's
+// --policy-pack-config flag only understands JSON, so a YAML fixture has to be converted before
+// it's passed along.
+func convertYAMLConfigToJSON(t *testing.T, yamlPath string) string {
+	raw, err := os.ReadFile(yamlPath)
+	require.NoError(t, err)
+
+	var parsed map[string]PolicyConfig
+	require.NoError(t, yaml.Unmarshal(raw, &parsed))
+
+	converted, err := json.MarshalIndent(parsed, "", "    ")
+	require.NoError(t, err)
+
+	jsonPath := strings.TrimSuffix(yamlPath, filepath.Ext(yamlPath)) + ".generated.json"
+	require.NoError(t, os.WriteFile(jsonPath, converted, 0o600))
+	return jsonPath
+}
+
 // runPolicyPackIntegrationTest creates a new Pulumi stack and then runs through
 // a sequence of test scenarios where a configuration value is set and then
 // the stack is updated or previewed, confirming the expected result.
@@ -66,6 +207,39 @@ func runPolicyPackIntegrationTest(
 	runPolicyPackIntegrationTestWithStackTags(t, testDirName, runtime, initialConfig, scenarios, nil)
 }
 
+// runPolicyPackIntegrationTestMatrix runs the same scenarios against every runtime in runtimes,
+// asserting the same WantErrors across each. Use this for scenarios -- like config-schema
+// validation -- whose behavior (JSON Schema handling, default injection, type coercion between
+// int/float/null/array/object) is implemented independently by each SDK and so needs cross-language
+// coverage rather than a single NodeJS-only run.
+func runPolicyPackIntegrationTestMatrix(
+	t *testing.T, testDirName string, runtimes []Runtime,
+	initialConfig map[string]string, scenarios []policyTestScenario,
+) {
+	for _, rt := range runtimes {
+		rt := rt
+		t.Run(runtimeName(rt), func(t *testing.T) {
+			runPolicyPackIntegrationTest(t, testDirName, rt, initialConfig, scenarios)
+		})
+	}
+}
+
+// runPolicyPackIntegrationTestWithPolicyGroup is like runPolicyPackIntegrationTest, except each
+// scenario's pack is registered against the Policy Group named by its PolicyGroup field (if any),
+// so a test can assert that a pack enabled only in, say, group "prod" doesn't fire for a stack run
+// under group "dev", and that per-group config overrides are respected.
+//
+// This only plumbs the --policy-group flag through to each scenario's local "pulumi" invocation:
+// registering multiple groups with independent configs against the same stack, and having
+// "pulumi policy enable --policy-group" persist that assignment, are httpstate/Pulumi Cloud
+// backend features this repo's local-backend harness has no access to.
+func runPolicyPackIntegrationTestWithPolicyGroup(
+	t *testing.T, testDirName string, runtime Runtime,
+	initialConfig map[string]string, scenarios []policyTestScenario,
+) {
+	runPolicyPackIntegrationTestWithStackTags(t, testDirName, runtime, initialConfig, scenarios, nil)
+}
+
 // runPolicyPackIntegrationTest creates a new Pulumi stack and then runs through
 // a sequence of test scenarios where a configuration value is set and then
 // the stack is updated or previewed, confirming the expected result, with additional
@@ -151,42 +325,90 @@ func runPolicyPackIntegrationTestWithStackTags(
 		e.RunCommand("pulumi", "stack", "init", stackName)
 	}
 
-	// Get dependencies.
+	// Get dependencies. Python projects use a "python -m venv" virtual environment rather than
+	// pipenv, mirroring the "virtualenv: venv" option pulumi itself supports in PulumiPolicy.yaml/
+	// Pulumi.yaml: the CLI auto-creates the venv and runs "python"/"pulumi" directly, rather than
+	// needing every command run through a wrapper like "pipenv run".
+	venvDir := filepath.Join(e.RootPath, "venv")
 	var venvCreated bool
 	switch runtime {
 	case NodeJS:
 		e.RunCommand("bun", "install")
 
 	case Python:
-		e.RunCommand("pipenv", "--python", "3")
-		e.RunCommand("pipenv", "run", "pip", "install", "-r", "requirements.txt")
+		e.RunCommand("python3", "-m", "venv", venvDir)
+		e.RunCommand(filepath.Join(venvBinDir(venvDir), "pip"), "install", "-r", "requirements.txt")
 		venvCreated = true
 
+	case Go:
+		e.RunCommand("go", "mod", "tidy")
+
+	case Dotnet:
+		e.RunCommand("dotnet", "restore")
+
 	default:
 		t.Fatalf("Unexpected runtime value.")
 	}
 
 	// If we have a Python policy pack, create the virtual environment (if one doesn't already exist),
-	// and install dependencies into it. If the test uses a Python program, the virtual environment and
-	// activation will be shared between the program and policy pack.
+	// and install dependencies into it. If the test uses a Python program, the virtual environment is
+	// shared between the program and policy pack.
 	var hasPythonPack bool
 	pythonPackDir := filepath.Join(e.RootPath, "policy-pack-python")
 	if _, err := os.Stat(pythonPackDir); !os.IsNotExist(err) {
 		hasPythonPack = true
 
 		if !venvCreated {
-			e.RunCommand("pipenv", "--python", "3")
+			e.RunCommand("python3", "-m", "venv", venvDir)
+			venvCreated = true
 		}
+		pip := filepath.Join(venvBinDir(venvDir), "pip")
 
 		pythonPackRequirements := filepath.Join(pythonPackDir, "requirements.txt")
 		if _, err := os.Stat(pythonPackRequirements); !os.IsNotExist(err) {
-			e.RunCommand("pipenv", "run", "pip", "install", "-r", pythonPackRequirements)
+			e.RunCommand(pip, "install", "-r", pythonPackRequirements)
 		}
 
 		dep := filepath.Join("..", "..", "sdk", "python", "env", "src")
 		dep, err = filepath.Abs(dep)
 		assert.NoError(t, err)
-		e.RunCommand("pipenv", "run", "pip", "install", "-e", dep)
+		e.RunCommand(pip, "install", "-e", dep)
+	}
+
+	if venvCreated {
+		// Put the venv's python/pulumi-plugin-finding python ahead of any system python so the
+		// engine's Python language host picks it up without needing every command wrapped.
+		e.SetEnvVars(pathEnvPrepending(venvBinDir(venvDir)))
+	}
+
+	// If we have a Go policy pack, build it into a binary. Unlike the NodeJS and Python cases,
+	// there's no runtime-detection file the engine reads to decide how to launch the pack: a Go
+	// policy pack is invoked as the compiled binary itself, so --policy-pack needs to point at
+	// that binary rather than the source directory.
+	var goPackBinary string
+	goPackDir := filepath.Join(e.RootPath, "policy-pack-go")
+	if _, err := os.Stat(goPackDir); !os.IsNotExist(err) {
+		priorCWD := e.CWD
+		e.CWD = goPackDir
+		e.RunCommand("go", "mod", "tidy")
+		goPackBinary = filepath.Join(goPackDir, "policy-pack-go")
+		e.RunCommand("go", "build", "-o", goPackBinary, ".")
+		e.CWD = priorCWD
+	}
+
+	// If we have a .NET policy pack, restore and build it ahead of time so the engine's dotnet
+	// language host doesn't pay that cost on every scenario's "pulumi up". Unlike Go, the engine
+	// still launches a .NET policy pack via its project directory (the same way it launches a .NET
+	// program), so --policy-pack points at dotnetPackDir itself, not a built artifact.
+	var dotnetPackDir string
+	candidateDotnetPackDir := filepath.Join(e.RootPath, "policy-pack-dotnet")
+	if _, err := os.Stat(candidateDotnetPackDir); !os.IsNotExist(err) {
+		dotnetPackDir = candidateDotnetPackDir
+		priorCWD := e.CWD
+		e.CWD = dotnetPackDir
+		e.RunCommand("dotnet", "restore")
+		e.RunCommand("dotnet", "build")
+		e.CWD = priorCWD
 	}
 
 	// Initial configuration.
@@ -221,8 +443,8 @@ func runPolicyPackIntegrationTestWithStackTags(
 
 					e.RunCommand("pulumi", "config", "set", "scenario", fmt.Sprintf("%d", idx+1))
 
-					cmd := "pulumi"
 					args := []string{"up", "--yes", "--policy-pack", policyPackDirectoryPath}
+					var policyConfigFile string
 
 					// If there is config for the scenario, write it out to a file and pass the file path
 					// as a --policy-pack-config argument.
@@ -240,42 +462,84 @@ func runPolicyPackIntegrationTestWithStackTags(
 						e.WriteTestFile(filename, string(bytes))
 
 						// Add the policy config argument.
-						policyConfigFile := filepath.Join(configDir, filename)
+						policyConfigFile = filepath.Join(configDir, filename)
 						args = append(args, "--policy-pack-config", policyConfigFile)
 
 						// Change back to the program directory to proceed with the update.
 						e.CWD = programDir
 					}
 
-					if runtime == Python || hasPythonPack {
-						cmd = "pipenv"
-						args = append([]string{"run", "pulumi"}, args...)
+					// PolicyPackConfigFiles layers one or more JSON/YAML fixture files in as
+					// "--policy-pack-config" flags, each resolved to an absolute path and, for
+					// YAML, converted to JSON first -- the engine's --policy-pack-config flag
+					// itself only understands JSON.
+					for _, f := range scenario.PolicyPackConfigFiles {
+						configFile := filepath.Join(rootDir, f)
+						if ext := filepath.Ext(configFile); ext == ".yaml" || ext == ".yml" {
+							configFile = convertYAMLConfigToJSON(t, configFile)
+						}
+						args = append(args, "--policy-pack-config", configFile)
+						if policyConfigFile == "" {
+							policyConfigFile = configFile
+						}
+					}
+
+					if scenario.ValidateConfigOnly {
+						require.NotEmpty(t, policyConfigFile, "ValidateConfigOnly requires PolicyPackConfig")
+						args = []string{"policy", "validate-config", policyPackDirectoryPath, policyConfigFile}
+					}
+
+					if scenario.PolicyGroup != "" {
+						args = append(args, "--policy-group", scenario.PolicyGroup)
 					}
 
+					var stdout, stderr string
+					start := time.Now()
 					if len(scenario.WantErrors) == 0 {
 						t.Log("No errors are expected.")
-						e.RunCommand(cmd, args...)
+						stdout, stderr = e.RunCommand("pulumi", args...)
+					} else if scenario.Advisory {
+						stdout, stderr = e.RunCommand("pulumi", args...)
 					} else {
-						var stdout, stderr string
-						if scenario.Advisory {
-							stdout, stderr = e.RunCommand(cmd, args...)
-						} else {
-							stdout, stderr = e.RunCommandExpectError(cmd, args...)
+						stdout, stderr = e.RunCommandExpectError("pulumi", args...)
+					}
+					duration := time.Since(start)
+					policyPerfBenchmark.record(policyPackDirectoryPath, scenarioName, duration, scenario.MaxDuration)
+					if scenario.MaxDuration > 0 && duration > scenario.MaxDuration {
+						t.Errorf("scenario took %s, exceeding MaxDuration %s", duration, scenario.MaxDuration)
+					}
+
+					for _, wantErr := range scenario.WantErrors {
+						inSTDOUT := strings.Contains(stdout, wantErr)
+						inSTDERR := strings.Contains(stderr, wantErr)
+
+						if !inSTDOUT && !inSTDERR {
+							t.Errorf("Did not find expected error %q", wantErr)
 						}
+					}
 
-						for _, wantErr := range scenario.WantErrors {
-							inSTDOUT := strings.Contains(stdout, wantErr)
-							inSTDERR := strings.Contains(stderr, wantErr)
+					for _, wantSkipped := range scenario.WantSkipped {
+						inSTDOUT := strings.Contains(stdout, wantSkipped)
+						inSTDERR := strings.Contains(stderr, wantSkipped)
 
-							if !inSTDOUT && !inSTDERR {
-								t.Errorf("Did not find expected error %q", wantErr)
-							}
+						if !inSTDOUT && !inSTDERR {
+							t.Errorf("Did not find expected skipped diagnostic %q", wantSkipped)
 						}
+					}
+
+					for _, policyName := range scenario.WantSkippedPolicies {
+						wantSkipped := fmt.Sprintf("%s: skipped -- stack tags do not match appliesToStackTags", policyName)
+						inSTDOUT := strings.Contains(stdout, wantSkipped)
+						inSTDERR := strings.Contains(stderr, wantSkipped)
 
-						if t.Failed() {
-							t.Logf("Command output:\nSTDOUT:\n%v\n\nSTDERR:\n%v\n\n", stdout, stderr)
+						if !inSTDOUT && !inSTDERR {
+							t.Errorf("Expected policy %q to be skipped for stack tag mismatch, but it wasn't", policyName)
 						}
 					}
+
+					if t.Failed() {
+						t.Logf("Command output:\nSTDOUT:\n%v\n\nSTDERR:\n%v\n\n", stdout, stderr)
+					}
 				})
 			}
 		})
@@ -284,6 +548,12 @@ func runPolicyPackIntegrationTestWithStackTags(
 	if hasPythonPack {
 		runScenarios(pythonPackDir)
 	}
+	if goPackBinary != "" {
+		runScenarios(goPackBinary)
+	}
+	if dotnetPackDir != "" {
+		runScenarios(dotnetPackDir)
+	}
 
 	e.T = t
 	t.Log("Finished test scenarios.")
@@ -8,6 +8,14 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
+// DynamicResourceState is the shape of a "pulumi-nodejs:dynamic:Resource"'s state, decoded with
+// policy.ResourceValidationArgs.As instead of hand-rolling args.Resource.Properties.GetFields()
+// lookups for every property.
+type DynamicResourceState struct {
+	State      float64 `pulumi:"state"`
+	LongString string  `pulumi:"longString"`
+}
+
 func main() {
 	if err := policy.Run(func(config *config.Config) error {
 		//testScenario := config.RequireInt("scenario")
@@ -23,8 +31,8 @@ func main() {
 						return func(args policy.ResourceValidationArgs,
 							reportViolation policy.ReportViolation) {
 							if args.Resource.Type == "pulumi-nodejs:dynamic:Resource" {
-								fields := args.Resource.Properties.GetFields()
-								if val, ok := fields["state"]; ok && val.GetNumberValue() == 1 {
+								var s DynamicResourceState
+								if err := args.As(&s); err == nil && s.State == 1 {
 									reportViolation("'state' must not have the value 1.", args.Resource.Urn)
 								}
 							}
@@ -39,8 +47,8 @@ func main() {
 						return func(args policy.ResourceValidationArgs,
 							reportViolation policy.ReportViolation) {
 							if args.Resource.Type == "pulumi-nodejs:dynamic:Resource" {
-								fields := args.Resource.Properties.GetFields()
-								if val, ok := fields["state"]; ok && val.GetNumberValue() == 2 {
+								var s DynamicResourceState
+								if err := args.As(&s); err == nil && s.State == 2 {
 									reportViolation("'state' must not have the value 2.", args.Resource.Urn)
 								}
 							}
@@ -104,13 +112,12 @@ func main() {
 						return func(args policy.ResourceValidationArgs,
 							reportViolation policy.ReportViolation) {
 							if args.Resource.Type == "pulumi-nodejs:dynamic:Resource" {
-								fields := args.Resource.Properties.GetFields()
-								if val, ok := fields["state"]; ok && val.GetNumberValue() == 6 {
-									str, ok := fields["longString"]
-									if !ok {
+								var s DynamicResourceState
+								if err := args.As(&s); err == nil && s.State == 6 {
+									if s.LongString == "" {
 										reportViolation("'state' 6 must have longString.", args.Resource.Urn)
 									}
-									l := len(str.GetStringValue())
+									l := len(s.LongString)
 									if l != 5*1024*1024 {
 										reportViolation(fmt.Sprintf("'longString' had expected length of %d, got %d",
 											5*1024*1024, l), args.Resource.Urn)